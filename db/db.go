@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"time" // [FIX] Wajib ditambahin buat ngatur waktu timeout
 
@@ -135,6 +136,309 @@ func InitDB() {
 		log.Println("✅ Tabel 'energy_logs' siap (IoT History).")
 	}
 
+	// 4. (AUTO-UPDATE) Kolom jumlah unit per perangkat di riwayat_perangkat
+	// Default 1 supaya data lama tetap dihitung sebagai satu unit.
+	addColumnIfMissing("riwayat_perangkat", "jumlah", "INT NOT NULL DEFAULT 1")
+
+	// 4.5 (AUTO-UPDATE) Durasi pecahan (contoh 0.25 jam = 15 menit)
+	// Cek tipe kolom dulu supaya MODIFY (rebuild tabel) tidak jalan setiap start.
+	var durasiType string
+	err = DB.QueryRow(`
+		SELECT DATA_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'riwayat_perangkat' AND COLUMN_NAME = 'durasi'
+	`).Scan(&durasiType)
+	if err != nil {
+		log.Printf("ℹ️ Info: Tidak bisa mengecek tipe kolom 'durasi': %v", err)
+	} else if durasiType != "decimal" {
+		_, err = DB.Exec(`ALTER TABLE riwayat_perangkat MODIFY COLUMN durasi DECIMAL(7,4) NULL`)
+		if err != nil {
+			log.Printf("❌ Warning: Gagal mengubah kolom 'durasi' ke DECIMAL: %v", err)
+		} else {
+			log.Println("✅ Kolom 'durasi' sekarang mendukung jam pecahan.")
+		}
+	}
+
+	// 5. Tabel Jadwal Perangkat (rentang jam pemakaian per hari)
+	createJadwalSQL := `
+		CREATE TABLE IF NOT EXISTS jadwal_perangkat (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			riwayat_id INT NOT NULL,
+			hari TINYINT NOT NULL,
+			jam_mulai TIME NOT NULL,
+			jam_selesai TIME NOT NULL,
+			INDEX idx_jadwal_riwayat (riwayat_id)
+		);
+	`
+	_, err = DB.Exec(createJadwalSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel jadwal_perangkat: %v", err)
+	}
+
+	// 6. Tabel Ruangan (kamar tidur, dapur, dll) per user
+	createRuanganSQL := `
+		CREATE TABLE IF NOT EXISTS ruangan (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			nama_ruangan VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_ruangan_user_nama (user_id, nama_ruangan)
+		);
+	`
+	_, err = DB.Exec(createRuanganSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel ruangan: %v", err)
+	}
+	addColumnIfMissing("riwayat_perangkat", "ruangan_id", "INT NULL DEFAULT NULL")
+
+	// 7. Tabel Perangkat IoT (label sensor -> ruangan)
+	createPerangkatIotSQL := `
+		CREATE TABLE IF NOT EXISTS perangkat_iot (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			device_label VARCHAR(50) NOT NULL,
+			ruangan_id INT NULL,
+			UNIQUE KEY uq_iot_user_label (user_id, device_label)
+		);
+	`
+	_, err = DB.Exec(createPerangkatIotSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel perangkat_iot: %v", err)
+	}
+
+	// 8. (AUTO-UPDATE) Siklus hidup perangkat + harga katalog untuk hitung balik modal
+	addColumnIfMissing("riwayat_perangkat", "tanggal_beli", "DATE NULL DEFAULT NULL")
+	addColumnIfMissing("riwayat_perangkat", "garansi_sampai", "DATE NULL DEFAULT NULL")
+	addColumnIfMissing("riwayat_perangkat", "umur_pakai_tahun", "INT NULL DEFAULT NULL")
+	addColumnIfMissing("produk", "harga", "DECIMAL(12,2) NULL DEFAULT NULL")
+
+	// 9. (AUTO-UPDATE) Soft delete: baris yang dihapus masuk sampah dulu sebelum dipurge
+	addColumnIfMissing("riwayat_perangkat", "deleted_at", "DATETIME NULL DEFAULT NULL")
+
+	// 10. Idempotency key untuk submit (retry dari jaringan HP tidak bikin batch dobel)
+	createIdempotencySQL := `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			endpoint VARCHAR(64) NOT NULL,
+			idem_key VARCHAR(255) NOT NULL,
+			request_hash CHAR(64) NOT NULL,
+			status_code INT NULL,
+			response_body MEDIUMTEXT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_idempotency_user_key (user_id, endpoint, idem_key),
+			INDEX idx_idempotency_created (created_at)
+		);
+	`
+	_, err = DB.Exec(createIdempotencySQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel idempotency_keys: %v", err)
+	}
+
+	// 11. Tabel Tarif Listrik PLN per golongan, daya, dan tanggal berlaku
+	createTarifSQL := `
+		CREATE TABLE IF NOT EXISTS tarif_listrik (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			golongan VARCHAR(20) NOT NULL,
+			daya_min INT NOT NULL,
+			daya_max INT NULL,
+			subsidi BOOLEAN NOT NULL DEFAULT FALSE,
+			tarif_per_kwh DECIMAL(10,2) NOT NULL,
+			berlaku_mulai DATE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_tarif_golongan (golongan, daya_min, subsidi, berlaku_mulai)
+		);
+	`
+	_, err = DB.Exec(createTarifSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel tarif_listrik: %v", err)
+	} else {
+		seedTarifListrik()
+	}
+
+	// 12. Listrik prabayar: pembelian token dan pembacaan sisa kWh di meter
+	createTokenListrikSQL := `
+		CREATE TABLE IF NOT EXISTS token_listrik (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			nominal_rp DECIMAL(12,2) NOT NULL,
+			kwh DECIMAL(10,2) NOT NULL,
+			nomor_token CHAR(20) NOT NULL,
+			tanggal_beli DATETIME NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_token_user_tanggal (user_id, tanggal_beli)
+		);
+	`
+	_, err = DB.Exec(createTokenListrikSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel token_listrik: %v", err)
+	}
+
+	createSaldoMeterSQL := `
+		CREATE TABLE IF NOT EXISTS saldo_meter (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			sisa_kwh DECIMAL(10,2) NOT NULL,
+			tanggal_baca DATETIME NOT NULL,
+			sumber VARCHAR(20) NOT NULL DEFAULT 'manual',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_saldo_user_tanggal (user_id, tanggal_baca)
+		);
+	`
+	_, err = DB.Exec(createSaldoMeterSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel saldo_meter: %v", err)
+	}
+
+	// 13. Catatan notifikasi yang sudah dikirim (supaya peringatan tidak dikirim berulang)
+	createNotifikasiSQL := `
+		CREATE TABLE IF NOT EXISTS notifikasi_terkirim (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			jenis VARCHAR(30) NOT NULL,
+			kunci VARCHAR(64) NOT NULL,
+			sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_notifikasi (user_id, jenis, kunci)
+		);
+	`
+	_, err = DB.Exec(createNotifikasiSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel notifikasi_terkirim: %v", err)
+	}
+
+	// 14. Tagihan listrik pascabayar per bulan (untuk dibandingkan dengan perkiraan)
+	createTagihanSQL := `
+		CREATE TABLE IF NOT EXISTS tagihan_listrik (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			periode CHAR(7) NOT NULL,
+			kwh DECIMAL(10,2) NULL,
+			jumlah_rp DECIMAL(12,2) NULL,
+			sumber VARCHAR(20) NOT NULL DEFAULT 'manual',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_tagihan_user_periode (user_id, periode)
+		);
+	`
+	_, err = DB.Exec(createTagihanSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel tagihan_listrik: %v", err)
+	}
+
+	// 15. Komponen tagihan per wilayah (PPJ, biaya admin, bea materai, rekening minimum)
+	createWilayahSQL := `
+		CREATE TABLE IF NOT EXISTS wilayah_tarif (
+			kode_wilayah VARCHAR(20) PRIMARY KEY,
+			nama_wilayah VARCHAR(100) NOT NULL,
+			ppj_persen DECIMAL(5,2) NOT NULL DEFAULT 3,
+			biaya_admin DECIMAL(10,2) NOT NULL DEFAULT 0,
+			bea_materai DECIMAL(10,2) NOT NULL DEFAULT 0,
+			materai_min_tagihan DECIMAL(12,2) NOT NULL DEFAULT 5000000,
+			jam_nyala_min DECIMAL(5,1) NOT NULL DEFAULT 40
+		);
+	`
+	_, err = DB.Exec(createWilayahSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel wilayah_tarif: %v", err)
+	} else {
+		_, err = DB.Exec(`
+			INSERT IGNORE INTO wilayah_tarif (kode_wilayah, nama_wilayah, ppj_persen, biaya_admin, bea_materai, materai_min_tagihan, jam_nyala_min)
+			VALUES ('DEFAULT', 'Default', 3, 2500, 10000, 5000000, 40)`)
+		if err != nil {
+			log.Printf("❌ Warning: Gagal mengisi wilayah DEFAULT: %v", err)
+		}
+	}
+	addColumnIfMissing("users", "kode_wilayah", "VARCHAR(20) NULL DEFAULT NULL")
+	// Pelanggan bersubsidi memakai tarif subsidi kalau tersedia untuk dayanya
+	addColumnIfMissing("users", "subsidi", "BOOLEAN NOT NULL DEFAULT FALSE")
+
+	// 16. Tarif waktu pemakaian: WBP (beban puncak) dan LWBP per rentang daya
+	createTarifWbpSQL := `
+		CREATE TABLE IF NOT EXISTS tarif_wbp (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			daya_min INT NOT NULL,
+			daya_max INT NULL,
+			wbp_mulai TIME NOT NULL DEFAULT '17:00:00',
+			wbp_selesai TIME NOT NULL DEFAULT '22:00:00',
+			tarif_wbp DECIMAL(10,2) NOT NULL,
+			tarif_lwbp DECIMAL(10,2) NOT NULL,
+			berlaku_mulai DATE NOT NULL
+		);
+	`
+	_, err = DB.Exec(createTarifWbpSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel tarif_wbp: %v", err)
+	} else {
+		// Data awal: golongan 6.600 VA ke atas, WBP = 1,4 x LWBP
+		_, err = DB.Exec(`
+			INSERT INTO tarif_wbp (daya_min, daya_max, wbp_mulai, wbp_selesai, tarif_wbp, tarif_lwbp, berlaku_mulai)
+			SELECT 5501, NULL, '17:00:00', '22:00:00', 2379.34, 1699.53, '2024-01-01'
+			FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM tarif_wbp)`)
+		if err != nil {
+			log.Printf("❌ Warning: Gagal mengisi data tarif_wbp: %v", err)
+		}
+	}
+
+	// 17. Anggaran listrik bulanan per user (peringatan lewat notifikasi_terkirim)
+	createAnggaranSQL := `
+		CREATE TABLE IF NOT EXISTS anggaran (
+			user_id INT PRIMARY KEY,
+			batas_rp DECIMAL(12,2) NOT NULL,
+			ambang_persen VARCHAR(50) NOT NULL DEFAULT '80,100',
+			aktif BOOLEAN NOT NULL DEFAULT TRUE,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);
+	`
+	_, err = DB.Exec(createAnggaranSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel anggaran: %v", err)
+	}
+
+	// 18. Profil irradiasi matahari per kota (Wh/m2 per jam, rata-rata tiap bulan) untuk simulasi PLTS atap
+	createIrradiasiSQL := `
+		CREATE TABLE IF NOT EXISTS irradiasi_kota (
+			kota VARCHAR(50) NOT NULL,
+			bulan TINYINT NOT NULL,
+			jam TINYINT NOT NULL,
+			wh_per_m2 DECIMAL(8,2) NOT NULL,
+			PRIMARY KEY (kota, bulan, jam)
+		);
+	`
+	_, err = DB.Exec(createIrradiasiSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel irradiasi_kota: %v", err)
+	} else {
+		seedIrradiasiKota()
+	}
+
+	// 19. (AUTO-UPDATE) Sensor IoT bisa ditautkan ke satu perangkat di riwayat (untuk bandingkan terukur vs rating)
+	addColumnIfMissing("perangkat_iot", "riwayat_id", "INT NULL DEFAULT NULL")
+	// Sensor yang mengukur seluruh rumah ditandai meter_utama; sensor lain yang tidak ditautkan tidak dihitung sebagai total rumah
+	addColumnIfMissing("perangkat_iot", "meter_utama", "BOOLEAN NOT NULL DEFAULT FALSE")
+
+	// 20. Benchmark antar rumah tangga: jumlah penghuni + distribusi kWh bulanan per kohort (hanya kohort >= k user)
+	addColumnIfMissing("users", "jumlah_penghuni", "INT NULL DEFAULT NULL")
+	createBenchmarkSQL := `
+		CREATE TABLE IF NOT EXISTS benchmark_kohort (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			periode CHAR(7) NOT NULL,
+			kelas_daya VARCHAR(20) NOT NULL,
+			kode_wilayah VARCHAR(20) NOT NULL,
+			kelompok_penghuni VARCHAR(10) NOT NULL,
+			jumlah_user INT NOT NULL,
+			p10 DECIMAL(10,2) NOT NULL,
+			p25 DECIMAL(10,2) NOT NULL,
+			median DECIMAL(10,2) NOT NULL,
+			p75 DECIMAL(10,2) NOT NULL,
+			p90 DECIMAL(10,2) NOT NULL,
+			dihitung_pada TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_benchmark_kohort (periode, kelas_daya, kode_wilayah, kelompok_penghuni)
+		);
+	`
+	_, err = DB.Exec(createBenchmarkSQL)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal membuat tabel benchmark_kohort: %v", err)
+	}
+
 	// Cek jumlah data merek (Logic lama)
 	var count int
 	err = DB.QueryRow("SELECT COUNT(*) FROM merek").Scan(&count)
@@ -145,4 +449,96 @@ func InitDB() {
 	}
 
 	log.Println("✅ Database berhasil terkoneksi")
-}
\ No newline at end of file
+}
+
+// addColumnIfMissing menjalankan ALTER TABLE ADD COLUMN. Kalau kolom sudah ada
+// (error 1060 "Duplicate column name"), cukup dicatat sebagai info.
+func addColumnIfMissing(table, column, definition string) {
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := DB.Exec(query); err != nil {
+		log.Printf("ℹ️ Info: Kolom '%s.%s' mungkin sudah ada (Database msg: %v)", table, column, err)
+		return
+	}
+	log.Printf("✅ Sukses menambahkan kolom '%s' ke tabel %s!", column, table)
+}
+
+// seedTarifListrik mengisi tarif tenaga listrik rumah tangga PLN kalau tabel masih kosong.
+// Update berikutnya lewat admin API (/admin/tariffs), bukan di sini.
+func seedTarifListrik() {
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM tarif_listrik").Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO tarif_listrik (golongan, daya_min, daya_max, subsidi, tarif_per_kwh, berlaku_mulai) VALUES
+		('R-1/TR', 0, 450, TRUE, 415.00, '2024-01-01'),
+		('R-1/TR', 451, 900, TRUE, 605.00, '2024-01-01'),
+		('R-1/TR', 451, 900, FALSE, 1352.00, '2024-01-01'),
+		('R-1/TR', 901, 2200, FALSE, 1444.70, '2024-01-01'),
+		('R-2/TR', 2201, 5500, FALSE, 1699.53, '2024-01-01'),
+		('R-3/TR', 5501, NULL, FALSE, 1699.53, '2024-01-01')`)
+	if err != nil {
+		log.Printf("❌ Warning: Gagal mengisi data tarif_listrik: %v", err)
+		return
+	}
+	log.Println("✅ Data awal tarif_listrik berhasil diisi.")
+}
+
+// seedIrradiasiKota mengisi profil irradiasi per jam dari rata-rata peak sun hours (kWh/m2/hari) tiap bulan.
+// Sebaran per jam memakai kurva sinus 06.00-18.00; data bisa diganti dengan hasil ukur lokal.
+func seedIrradiasiKota() {
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM irradiasi_kota").Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	peakSunHours := map[string][12]float64{
+		"Jakarta":    {4.3, 4.4, 4.7, 4.8, 4.7, 4.6, 4.8, 5.1, 5.3, 5.1, 4.7, 4.4},
+		"Bandung":    {4.2, 4.3, 4.5, 4.6, 4.6, 4.5, 4.8, 5.1, 5.2, 4.9, 4.4, 4.2},
+		"Semarang":   {4.4, 4.5, 4.8, 4.9, 4.9, 4.8, 5.0, 5.4, 5.6, 5.4, 4.9, 4.5},
+		"Yogyakarta": {4.4, 4.5, 4.7, 4.9, 4.9, 4.8, 5.0, 5.4, 5.6, 5.3, 4.8, 4.5},
+		"Surabaya":   {4.6, 4.7, 4.9, 5.0, 5.0, 4.9, 5.2, 5.7, 6.0, 5.9, 5.3, 4.8},
+		"Denpasar":   {5.0, 5.1, 5.3, 5.4, 5.2, 5.0, 5.2, 5.7, 6.1, 6.2, 5.7, 5.2},
+		"Medan":      {4.4, 4.8, 4.9, 4.8, 4.6, 4.7, 4.6, 4.5, 4.3, 4.2, 4.1, 4.1},
+		"Makassar":   {4.4, 4.6, 4.9, 5.2, 5.3, 5.2, 5.6, 6.0, 6.2, 6.0, 5.2, 4.5},
+	}
+
+	var shape [24]float64
+	shapeTotal := 0.0
+	for hour := 6; hour < 18; hour++ {
+		shape[hour] = math.Sin(math.Pi * (float64(hour) + 0.5 - 6) / 12)
+		shapeTotal += shape[hour]
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Printf("❌ Warning: Gagal mengisi data irradiasi_kota: %v", err)
+		return
+	}
+	stmt, err := tx.Prepare("INSERT INTO irradiasi_kota (kota, bulan, jam, wh_per_m2) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		log.Printf("❌ Warning: Gagal mengisi data irradiasi_kota: %v", err)
+		return
+	}
+	defer stmt.Close()
+
+	for city, months := range peakSunHours {
+		for month, psh := range months {
+			for hour := 6; hour < 18; hour++ {
+				wh := math.Round(psh*1000*shape[hour]/shapeTotal*100) / 100
+				if _, err := stmt.Exec(city, month+1, hour, wh); err != nil {
+					tx.Rollback()
+					log.Printf("❌ Warning: Gagal mengisi data irradiasi_kota: %v", err)
+					return
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("❌ Warning: Gagal mengisi data irradiasi_kota: %v", err)
+		return
+	}
+	log.Println("✅ Data awal irradiasi_kota berhasil diisi.")
+}
@@ -54,15 +54,22 @@ func AnalyzeHandler(w http.ResponseWriter, r *http.Request, model *genai.Generat
 		return
 	}
 
-	totalDailyWattHours := 0
+	dailyWattHours := 0.0
 	for _, device := range req.Devices {
-		totalDailyWattHours += device.Power * device.Duration
+		if err := validateSchedule(device.Schedule); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid schedule for %s: %v", device.Name, err), http.StatusBadRequest)
+			return
+		}
+		hours := averageDailyHours(device.Schedule, resolveDurationHours(device.Duration, device.DurationMinutes))
+		dailyWattHours += float64(device.Power*normalizeQuantity(device.Quantity)) * hours
 	}
+	totalDailyWattHours := int(math.Round(dailyWattHours))
 
-	dailyKWh := float64(totalDailyWattHours) / 1000.0
+	dailyKWh := dailyWattHours / 1000.0
 	monthlyKWh := dailyKWh * 30
-	tariffRate := getTariffRate(req.BesarListrik)
-	estimatedMonthlyCost := monthlyKWh * tariffRate
+	tariffRate := tariffPerKwh(req.BesarListrik, userSubsidized(userID))
+	bill := monthlyBillForUser(userID, monthlyKWh, req.BesarListrik)
+	estimatedMonthlyCost := bill.Total
 
 	prompt := buildPrompt(req.Devices)
 	ctx := r.Context()
@@ -76,7 +83,7 @@ func AnalyzeHandler(w http.ResponseWriter, r *http.Request, model *genai.Generat
 
 	var idSubmit string
 	var riwayatID int
-	err = db.DB.QueryRow(`SELECT id_submit, id FROM riwayat_perangkat WHERE user_id = ? ORDER BY tanggal_input DESC, id DESC LIMIT 1`, userID).Scan(&idSubmit, &riwayatID)
+	err = db.DB.QueryRow(`SELECT id_submit, id FROM riwayat_perangkat WHERE user_id = ? AND deleted_at IS NULL ORDER BY tanggal_input DESC, id DESC LIMIT 1`, userID).Scan(&idSubmit, &riwayatID)
 	if err != nil {
 		riwayatID = 0
 	}
@@ -92,6 +99,7 @@ func AnalyzeHandler(w http.ResponseWriter, r *http.Request, model *genai.Generat
 		"monthly_kwh":          monthlyKWh,
 		"tariff_rate":          tariffRate,
 		"estimated_monthly_rp": formatRupiah(estimatedMonthlyCost),
+		"bill":                 bill,
 		"ai_response":          aiResponse,
 		"id_submit":            idSubmit,
 		"besar_listrik":        req.BesarListrik,
@@ -145,29 +153,24 @@ func GetInsightHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// B. Ambil Total Pemakaian HARIAN dari Tabel HISTORY (riwayat_perangkat)
-	// Kita ambil SUM semua alat yang diinput di BULAN INI.
-	// Jadi kalau user nambah alat di history, grade langsung berubah.
-	var totalDailyWh int
-	queryHistorySum := `
-		SELECT COALESCE(SUM(daya * durasi), 0)
-		FROM riwayat_perangkat
-		WHERE user_id = ? 
-		AND MONTH(tanggal_input) = MONTH(CURDATE()) 
-		AND YEAR(tanggal_input) = YEAR(CURDATE())
-	`
-	err = db.DB.QueryRow(queryHistorySum, userID).Scan(&totalDailyWh)
+	// B. Ambil Pemakaian HARIAN dari submit terakhir (riwayat_perangkat)
+	// Rata-rata kWh per hari tiap alat mengikuti jadwalnya kalau ada.
+	// Jadi kalau user submit ulang atau ubah jadwal, grade langsung berubah.
+	records, err := latestSubmissionRecords(userID)
 	if err != nil {
-		totalDailyWh = 0
+		log.Printf("⚠️ GetInsightHandler: Gagal memuat perangkat: %v", err)
+	}
+	dailyKwh := 0.0
+	for _, rec := range records {
+		dailyKwh += rec.averageDailyKWh()
 	}
 
 	// Konversi ke Proyeksi Bulanan (x 30 hari)
-	// (Wh / 1000) = kWh -> dikali 30 hari
-	estimatedMonthlyKwh := (float64(totalDailyWh) / 1000.0) * 30
+	estimatedMonthlyKwh := dailyKwh * 30
 
 	// C. Ambil Kapasitas Listrik Rumah (VA)
 	var capacityStr string
-	queryCap := `SELECT besar_listrik FROM riwayat_perangkat WHERE user_id = ? ORDER BY id DESC LIMIT 1`
+	queryCap := `SELECT besar_listrik FROM riwayat_perangkat WHERE user_id = ? AND deleted_at IS NULL ORDER BY id DESC LIMIT 1`
 	err = db.DB.QueryRow(queryCap, userID).Scan(&capacityStr)
 	
 	capacity := 1300.0 // Default
@@ -230,7 +233,7 @@ func GetInsightHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// ... (Helper functions tetap sama: formatRupiah, getTariffRate, buildPrompt, formatAIResponse)
+// ... (Helper functions tetap sama: formatRupiah, buildPrompt, formatAIResponse)
 func formatRupiah(amount float64) string {
 	rounded := math.Floor(amount)
 	amountStr := fmt.Sprintf("%.0f", rounded)
@@ -245,21 +248,6 @@ func formatRupiah(amount float64) string {
 	return "Rp. " + result.String()
 }
 
-func getTariffRate(besarListrik string) float64 {
-	switch {
-	case strings.Contains(besarListrik, "900"):
-		return 1352
-	case strings.Contains(besarListrik, "1300"), strings.Contains(besarListrik, "2200"):
-		return 1444.70
-	case strings.Contains(besarListrik, "3500"), strings.Contains(besarListrik, "4400"), strings.Contains(besarListrik, "5500"):
-		return 1699.53
-	case strings.Contains(besarListrik, "6600"), strings.Contains(besarListrik, "7700"), strings.Contains(besarListrik, "9000"):
-		return 1699.53
-	default:
-		return 1500
-	}
-}
-
 func buildPrompt(devices []models.Device) string {
 	var sb strings.Builder
 	sb.WriteString("### Electricity Usage Analysis\n\n")
@@ -268,12 +256,36 @@ func buildPrompt(devices []models.Device) string {
 	}
 	sb.WriteString("### Devices:\n")
 	for _, device := range devices {
-		sb.WriteString(fmt.Sprintf("- %s (%d Watts), %d hours/day\n", device.Name, device.Power, device.Duration))
+		hours := resolveDurationHours(device.Duration, device.DurationMinutes)
+		if len(device.Schedule) > 0 {
+			sb.WriteString(fmt.Sprintf("- %dx %s (%d Watts each), schedule: %s (avg %s/day)\n",
+				normalizeQuantity(device.Quantity), device.Name, device.Power,
+				describeSchedule(device.Schedule), formatUsageDuration(averageDailyHours(device.Schedule, hours))))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("- %dx %s (%d Watts each), %s/day\n", normalizeQuantity(device.Quantity), device.Name, device.Power, formatUsageDuration(hours)))
 	}
 	sb.WriteString("\nResponse format:\n- Bullet points only.\n- Convert to kWh.\n- Suggestion.\n")
 	return sb.String()
 }
 
+// formatUsageDuration: 0.25 -> "15 minutes", 8 -> "8 hours", 1.5 -> "1 hour 30 minutes"
+func formatUsageDuration(hours float64) string {
+	h, m := splitHoursMinutes(hours)
+	hourUnit := "hours"
+	if h == 1 {
+		hourUnit = "hour"
+	}
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%d minutes", m)
+	case m == 0:
+		return fmt.Sprintf("%d %s", h, hourUnit)
+	default:
+		return fmt.Sprintf("%d %s %d minutes", h, hourUnit, m)
+	}
+}
+
 func formatAIResponse(resp *genai.GenerateContentResponse) string {
 	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
 		return "No AI response received."
@@ -3,10 +3,12 @@ package handlers
 
 import (
 	"EnerTrack-BE/db"
+	"EnerTrack-BE/models"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,42 +17,54 @@ import (
 
 // Struct untuk input data appliance
 type ApplianceInput struct {
-	Name         string `json:"name"`
-	Brand        string `json:"brand"`
-	CategoryID   int    `json:"category_id"`
-	PowerRating  int    `json:"power_rating"`
-	DailyUsage   int    `json:"daily_usage"`
-	Quantity     int    `json:"quantity"`
-	BesarListrik string `json:"besar_listrik"`
+	Name         string  `json:"name"`
+	Brand        string  `json:"brand"`
+	CategoryID   int     `json:"category_id"`
+	PowerRating  int     `json:"power_rating"`
+	DailyUsage   float64 `json:"daily_usage"`
+	DailyMinutes float64 `json:"daily_usage_minutes"`
+	Quantity     int     `json:"quantity"`
+	BesarListrik string  `json:"besar_listrik"`
+	RoomID       *int    `json:"room_id"`
+	// Schedule opsional; kalau diisi, daily_usage boleh kosong
+	Schedule []models.UsageWindow `json:"schedule"`
 }
 
 // Struct untuk update appliance
 type ApplianceUpdate struct {
-	ID           int    `json:"id"`
-	Name         string `json:"name"`
-	Brand        string `json:"brand"`
-	CategoryID   int    `json:"category_id"`
-	PowerRating  int    `json:"power_rating"`
-	DailyUsage   int    `json:"daily_usage"`
-	Quantity     int    `json:"quantity"`
-	BesarListrik string `json:"besar_listrik"`
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	Brand        string  `json:"brand"`
+	CategoryID   int     `json:"category_id"`
+	PowerRating  int     `json:"power_rating"`
+	DailyUsage   float64 `json:"daily_usage"`
+	DailyMinutes float64 `json:"daily_usage_minutes"`
+	Quantity     int     `json:"quantity"`
+	BesarListrik string  `json:"besar_listrik"`
+	RoomID       *int    `json:"room_id"`
 }
 
-func getTariffByCapacity(capacity string) float64 {
-	valStr := strings.Replace(strings.Split(capacity, " ")[0], ".", "", -1)
-	val, err := strconv.Atoi(valStr)
-	if err != nil {
-		return 1444.70
+// normalizeQuantity memastikan jumlah unit minimal 1 (data lama / input kosong)
+func normalizeQuantity(quantity int) int {
+	if quantity <= 0 {
+		return 1
 	}
+	return quantity
+}
 
-	if val <= 900 {
-		return 1352.0
-	}
-	if val <= 2200 {
-		return 1444.70
+// resolveDurationHours: jam per hari boleh pecahan; kalau jam kosong tapi menit diisi,
+// menit dikonversi ke jam (20 menit = 0.333 jam)
+func resolveDurationHours(hours, minutes float64) float64 {
+	if hours <= 0 && minutes > 0 {
+		return minutes / 60.0
 	}
-	// 3.500 VA ke atas
-	return 1699.53
+	return hours
+}
+
+// splitHoursMinutes memecah jam pecahan jadi jam dan menit (dibulatkan ke menit)
+func splitHoursMinutes(hours float64) (int, int) {
+	totalMinutes := int(math.Round(hours * 60))
+	return totalMinutes / 60, totalMinutes % 60
 }
 
 // Create
@@ -58,7 +72,7 @@ func CreateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -92,13 +106,25 @@ func CreateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validasi input (termasuk BesarListrik)
-	if input.Name == "" || input.PowerRating <= 0 || input.DailyUsage <= 0 || input.BesarListrik == "" {
+	input.DailyUsage = resolveDurationHours(input.DailyUsage, input.DailyMinutes)
+	if err := validateSchedule(input.Schedule); err != nil {
+		http.Error(w, fmt.Sprintf("Jadwal tidak valid: %v", err), http.StatusBadRequest)
+		return
+	}
+	if input.DailyUsage <= 0 && len(input.Schedule) > 0 {
+		input.DailyUsage = averageDailyHours(input.Schedule, 0)
+	}
+	if input.Name == "" || input.PowerRating <= 0 || input.DailyUsage <= 0 || input.DailyUsage > 24 || input.BesarListrik == "" {
 		http.Error(w, "Data tidak lengkap (termasuk besar listrik)", http.StatusBadRequest)
 		return
 	}
 
-	if input.Quantity <= 0 {
-		input.Quantity = 1 // Default quantity
+	input.Quantity = normalizeQuantity(input.Quantity)
+
+	roomID := nullableRoomID(input.RoomID)
+	if roomID != nil && !roomBelongsToUser(roomID.(int), userID) {
+		http.Error(w, "Ruangan tidak ditemukan", http.StatusBadRequest)
+		return
 	}
 
 	// Generate ID submit baru atau ambil yang sudah ada
@@ -106,7 +132,7 @@ func CreateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	err = db.DB.QueryRow(`
 		SELECT id_submit 
 		FROM riwayat_perangkat 
-		WHERE user_id = ? 
+		WHERE user_id = ? AND deleted_at IS NULL
 		ORDER BY tanggal_input DESC 
 		LIMIT 1`, userID).Scan(&idSubmit)
 
@@ -114,20 +140,29 @@ func CreateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 		idSubmit = fmt.Sprintf("SUBMIT_%d_%d", userID, time.Now().Unix())
 	}
 
-	dailyEnergyKWh := float64(input.PowerRating*input.DailyUsage*input.Quantity) / 1000.0
+	dailyEnergyKWh := float64(input.PowerRating*input.Quantity) * averageDailyHours(input.Schedule, input.DailyUsage) / 1000.0
 	weeklyUsage := dailyEnergyKWh * 7
 	monthlyUsage := dailyEnergyKWh * 30
 
-	tarifPerKWh := getTariffByCapacity(input.BesarListrik)
+	tarifPerKWh := tariffPerKwh(input.BesarListrik, userSubsidized(userID))
 	monthlyCost := monthlyUsage * tarifPerKWh
 	query := `
 		INSERT INTO riwayat_perangkat 
-		(user_id, id_submit, nama_perangkat, merek, kategori_id, daya, durasi, 
+		(user_id, id_submit, nama_perangkat, merek, kategori_id, daya, durasi, jumlah, ruangan_id,
 		 besar_listrik, Weekly_Usage, Monthly_Usage, Monthly_cost, tanggal_input) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())`
 
-	result, err := db.DB.Exec(query, userID, idSubmit, input.Name, input.Brand,
-		input.CategoryID, input.PowerRating, input.DailyUsage,
+	// Perangkat dan jadwalnya disimpan dalam satu transaksi
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("❌ Error starting transaction: %v", err)
+		http.Error(w, "Gagal menyimpan perangkat", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, userID, idSubmit, input.Name, input.Brand,
+		input.CategoryID, input.PowerRating, input.DailyUsage, input.Quantity, roomID,
 		input.BesarListrik, weeklyUsage, monthlyUsage, monthlyCost)
 
 	if err != nil {
@@ -141,6 +176,21 @@ func CreateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("❌ Error getting inserted ID: %v", err)
 	}
+	if len(input.Schedule) > 0 {
+		if err == nil {
+			err = saveSchedule(tx, int(insertedID), input.Schedule)
+		}
+		if err != nil {
+			log.Printf("❌ Error saving schedule: %v", err)
+			http.Error(w, "Gagal menyimpan jadwal perangkat", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("❌ Error committing appliance: %v", err)
+		http.Error(w, "Gagal menyimpan perangkat", http.StatusInternalServerError)
+		return
+	}
 
 	// Response
 	response := map[string]interface{}{
@@ -154,6 +204,8 @@ func CreateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 			"power_rating":  input.PowerRating,
 			"daily_usage":   input.DailyUsage,
 			"quantity":      input.Quantity,
+			"room_id":       roomID,
+			"schedule":      input.Schedule,
 			"besar_listrik": input.BesarListrik,
 			"daily_energy":  dailyEnergyKWh,
 			"monthly_usage": monthlyUsage,
@@ -203,7 +255,7 @@ func GetUserAppliancesHandler(w http.ResponseWriter, r *http.Request) {
 	err = db.DB.QueryRow(`
 		SELECT id_submit 
 		FROM riwayat_perangkat 
-		WHERE user_id = ? 
+		WHERE user_id = ? AND deleted_at IS NULL
 		ORDER BY tanggal_input DESC, id DESC 
 		LIMIT 1`, userID).Scan(&idSubmit)
 
@@ -216,13 +268,15 @@ func GetUserAppliancesHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Ambil semua appliances dengan id_submit tersebut, termasuk kategori dan besar_listrik
 	rows, err := db.DB.Query(`
-		SELECT rp.id, rp.nama_perangkat, rp.merek, rp.daya, rp.durasi, 
+		SELECT rp.id, rp.nama_perangkat, rp.merek, rp.daya, rp.durasi, COALESCE(rp.jumlah, 1),
 			   rp.Weekly_Usage, rp.Monthly_Usage, rp.Monthly_cost,
 			   COALESCE(rp.besar_listrik, '') as besar_listrik,
-			   COALESCE(k.nama_kategori, 'Others') as kategori
+			   COALESCE(k.nama_kategori, 'Others') as kategori,
+			   COALESCE(r.nama_ruangan, '') as ruangan
 		FROM riwayat_perangkat rp
 		LEFT JOIN kategori k ON rp.kategori_id = k.kategori_id
-		WHERE rp.user_id = ? AND rp.id_submit = ?`, userID, idSubmit)
+		LEFT JOIN ruangan r ON rp.ruangan_id = r.id
+		WHERE rp.user_id = ? AND rp.id_submit = ? AND rp.deleted_at IS NULL`, userID, idSubmit)
 
 	if err != nil {
 		log.Printf("❌ Gagal query database: %v", err)
@@ -231,32 +285,42 @@ func GetUserAppliancesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	schedules, err := loadUserSchedules(userID)
+	if err != nil {
+		log.Printf("⚠️ Gagal memuat jadwal perangkat, pakai durasi harian: %v", err)
+	}
+
 	var appliances []map[string]interface{}
 	for rows.Next() {
 		var id int
-		var name, brand, category, besarListrik string
-		var power, duration int
+		var name, brand, category, besarListrik, room string
+		var power, quantity int
+		var duration float64
 		var weeklyUsage, monthlyUsage, monthlyCost float64
 
-		if err := rows.Scan(&id, &name, &brand, &power, &duration, &weeklyUsage, &monthlyUsage, &monthlyCost, &besarListrik, &category); err != nil {
+		if err := rows.Scan(&id, &name, &brand, &power, &duration, &quantity, &weeklyUsage, &monthlyUsage, &monthlyCost, &besarListrik, &category, &room); err != nil {
 			log.Printf("❌ Error scanning row: %v", err)
 			continue
 		}
 
 		// Calculate daily energy for frontend compatibility
-		dailyEnergy := float64(power*duration) / 1000.0 // kWh per day
+		quantity = normalizeQuantity(quantity)
+		usageHours := averageDailyHours(schedules[id], duration)
+		dailyEnergy := float64(power*quantity) * usageHours / 1000.0 // kWh per day
 
 		appliance := map[string]interface{}{
 			"id":            id,
 			"name":          name,
 			"brand":         brand,
 			"category":      category,
+			"room":          room,
 			"powerRating":   power,
 			"dailyUsage":    duration,
-			"quantity":      1,
+			"quantity":      quantity,
 			"besarListrik":  besarListrik,
 			"dailyEnergy":   dailyEnergy,
 			"monthlyEnergy": monthlyUsage,
+			"schedule":      schedules[id],
 		}
 		appliances = append(appliances, appliance)
 	}
@@ -306,18 +370,24 @@ func UpdateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validasi input (termasuk BesarListrik)
-	if input.ID <= 0 || input.Name == "" || input.PowerRating <= 0 || input.DailyUsage <= 0 || input.BesarListrik == "" {
+	input.DailyUsage = resolveDurationHours(input.DailyUsage, input.DailyMinutes)
+	if input.ID <= 0 || input.Name == "" || input.PowerRating <= 0 || input.DailyUsage <= 0 || input.DailyUsage > 24 || input.BesarListrik == "" {
 		http.Error(w, "Data tidak lengkap atau tidak valid", http.StatusBadRequest)
 		return
 	}
 
-	if input.Quantity <= 0 {
-		input.Quantity = 1
+	input.Quantity = normalizeQuantity(input.Quantity)
+
+	// room_id kosong = ruangan lama dipertahankan (lepas ruangan lewat /rooms/assign)
+	roomID := nullableRoomID(input.RoomID)
+	if roomID != nil && !roomBelongsToUser(roomID.(int), userID) {
+		http.Error(w, "Ruangan tidak ditemukan", http.StatusBadRequest)
+		return
 	}
 
 	// Cek apakah appliance milik user ini
 	var existingUserID int
-	err = db.DB.QueryRow("SELECT user_id FROM riwayat_perangkat WHERE id = ?", input.ID).Scan(&existingUserID)
+	err = db.DB.QueryRow("SELECT user_id FROM riwayat_perangkat WHERE id = ? AND deleted_at IS NULL", input.ID).Scan(&existingUserID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Perangkat tidak ditemukan", http.StatusNotFound)
@@ -333,20 +403,28 @@ func UpdateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dailyEnergyKWh := float64(input.PowerRating*input.DailyUsage*input.Quantity) / 1000.0
+	// Kalau perangkat sudah punya jadwal, proyeksi ikut jadwal (bukan durasi harian)
+	schedules, err := loadUserSchedules(userID)
+	if err != nil {
+		log.Printf("⚠️ Gagal memuat jadwal perangkat, pakai durasi harian: %v", err)
+	}
+	usageHours := averageDailyHours(schedules[input.ID], input.DailyUsage)
+
+	dailyEnergyKWh := float64(input.PowerRating*input.Quantity) * usageHours / 1000.0
 	weeklyUsage := dailyEnergyKWh * 7
 	monthlyUsage := dailyEnergyKWh * 30
 
-	tarifPerKWh := getTariffByCapacity(input.BesarListrik)
+	tarifPerKWh := tariffPerKwh(input.BesarListrik, userSubsidized(userID))
 	monthlyCost := monthlyUsage * tarifPerKWh
 	query := `
 		UPDATE riwayat_perangkat 
-		SET nama_perangkat = ?, merek = ?, kategori_id = ?, daya = ?, durasi = ?,
+		SET nama_perangkat = ?, merek = ?, kategori_id = ?, daya = ?, durasi = ?, jumlah = ?,
+			ruangan_id = COALESCE(?, ruangan_id),
 			besar_listrik = ?, Weekly_Usage = ?, Monthly_Usage = ?, Monthly_cost = ?
-		WHERE id = ? AND user_id = ?`
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
 
 	result, err := db.DB.Exec(query, input.Name, input.Brand, input.CategoryID,
-		input.PowerRating, input.DailyUsage, input.BesarListrik, weeklyUsage, monthlyUsage, monthlyCost,
+		input.PowerRating, input.DailyUsage, input.Quantity, roomID, input.BesarListrik, weeklyUsage, monthlyUsage, monthlyCost,
 		input.ID, userID)
 
 	if err != nil {
@@ -372,6 +450,7 @@ func UpdateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 			"power_rating":  input.PowerRating,
 			"daily_usage":   input.DailyUsage,
 			"quantity":      input.Quantity,
+			"room_id":       roomID,
 			"besar_listrik": input.BesarListrik,
 			"daily_energy":  dailyEnergyKWh,
 			"monthly_usage": monthlyUsage,
@@ -383,7 +462,7 @@ func UpdateApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// DELETE - Hapus appliance
+// DELETE - Pindahkan appliance ke sampah (soft delete, bisa di-restore sampai dipurge)
 func DeleteApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -424,8 +503,9 @@ func DeleteApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	result, err := db.DB.Exec(`
-		DELETE FROM riwayat_perangkat 
-		WHERE id = ? AND user_id = ?`, requestData.ID, userID)
+		UPDATE riwayat_perangkat 
+		SET deleted_at = NOW()
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, requestData.ID, userID)
 
 	if err != nil {
 		log.Printf("❌ Gagal menghapus appliance: %v", err)
@@ -440,8 +520,10 @@ func DeleteApplianceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Perangkat berhasil dihapus",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Perangkat dipindahkan ke sampah",
+		"id":             requestData.ID,
+		"retention_days": TrashRetentionDays,
 	})
 }
 
@@ -488,20 +570,21 @@ func GetApplianceByIDHandler(w http.ResponseWriter, r *http.Request) {
 
 	var id int
 	var name, brand, category, besarListrik string
-	var power, duration int
+	var power, quantity int
+	var duration float64
 	var weeklyUsage, monthlyUsage, monthlyCost float64
 
 	query := `
-		SELECT rp.id, rp.nama_perangkat, rp.merek, rp.daya, rp.durasi, 
+		SELECT rp.id, rp.nama_perangkat, rp.merek, rp.daya, rp.durasi, COALESCE(rp.jumlah, 1),
 			   rp.Weekly_Usage, rp.Monthly_Usage, rp.Monthly_cost,
 			   COALESCE(rp.besar_listrik, '') as besar_listrik,
 			   COALESCE(k.nama_kategori, 'Others') as kategori
 		FROM riwayat_perangkat rp
 		LEFT JOIN kategori k ON rp.kategori_id = k.id
-		WHERE rp.id = ? AND rp.user_id = ?`
+		WHERE rp.id = ? AND rp.user_id = ? AND rp.deleted_at IS NULL`
 
 	err = db.DB.QueryRow(query, applianceID, userID).Scan(
-		&id, &name, &brand, &power, &duration,
+		&id, &name, &brand, &power, &duration, &quantity,
 		&weeklyUsage, &monthlyUsage, &monthlyCost, &besarListrik, &category)
 
 	if err != nil {
@@ -515,7 +598,14 @@ func GetApplianceByIDHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate daily energy
-	dailyEnergy := float64(power*duration) / 1000.0
+	schedules, err := loadUserSchedules(userID)
+	if err != nil {
+		log.Printf("⚠️ Gagal memuat jadwal perangkat, pakai durasi harian: %v", err)
+	}
+
+	quantity = normalizeQuantity(quantity)
+	usageHours := averageDailyHours(schedules[id], duration)
+	dailyEnergy := float64(power*quantity) * usageHours / 1000.0
 
 	appliance := map[string]interface{}{
 		"id":            id,
@@ -524,10 +614,11 @@ func GetApplianceByIDHandler(w http.ResponseWriter, r *http.Request) {
 		"category":      category,
 		"powerRating":   power,
 		"dailyUsage":    duration,
-		"quantity":      1,
+		"quantity":      quantity,
 		"besarListrik":  besarListrik,
 		"dailyEnergy":   dailyEnergy,
 		"monthlyEnergy": monthlyUsage,
+		"schedule":      schedules[id],
 	}
 
 	w.Header().Set("Content-Type", "application/json")
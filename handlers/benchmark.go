@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// benchmarkMinCohort: k-anonymity, kohort dengan kurang dari 10 rumah tidak disimpan maupun ditampilkan
+	benchmarkMinCohort = 10
+	benchmarkAny       = "*"
+	unknownOccupants   = "?"
+)
+
+// benchmarkLevels: urutan fallback kohort dari yang paling spesifik (daya, wilayah, penghuni) sampai semua user
+var benchmarkLevels = []struct {
+	Name                        string
+	Capacity, Region, Occupants bool
+}{
+	{"capacity_region_household", true, true, true},
+	{"capacity_household", true, false, true},
+	{"capacity_region", true, true, false},
+	{"capacity", true, false, false},
+	{"all", false, false, false},
+}
+
+type BenchmarkCohort struct {
+	Level      string  `json:"level"`
+	Capacity   string  `json:"capacity"`
+	Region     string  `json:"region"`
+	Occupants  string  `json:"occupants"`
+	Households int     `json:"households"`
+	P10        float64 `json:"p10"`
+	P25        float64 `json:"p25"`
+	Median     float64 `json:"median"`
+	P75        float64 `json:"p75"`
+	P90        float64 `json:"p90"`
+}
+
+type BenchmarkResponse struct {
+	Period      string           `json:"period"`
+	MonthlyKwh  float64          `json:"monthly_kwh"`
+	Percentile  *float64         `json:"percentile"`
+	Band        string           `json:"band"`
+	VsMedianPct *float64         `json:"vs_median_percent"`
+	Cohort      *BenchmarkCohort `json:"cohort"`
+	Explanation string           `json:"explanation"`
+	Capacity    string           `json:"capacity"`
+	Region      string           `json:"region"`
+	Occupants   string           `json:"occupants"`
+}
+
+// capacityClass memetakan besar listrik ke salah satu pilihan houseCapacities
+func capacityClass(besarListrik string) string {
+	va, ok := parseCapacityVA(besarListrik)
+	if !ok {
+		return ""
+	}
+	class := houseCapacities[0]
+	for _, capacity := range houseCapacities {
+		if capVA, _ := parseCapacityVA(capacity); capVA <= va {
+			class = capacity
+		}
+	}
+	return class
+}
+
+// occupantGroup mengelompokkan jumlah penghuni supaya kohort tidak terlalu kecil
+func occupantGroup(occupants sql.NullInt64) string {
+	switch {
+	case !occupants.Valid || occupants.Int64 <= 0:
+		return unknownOccupants
+	case occupants.Int64 == 1:
+		return "1"
+	case occupants.Int64 == 2:
+		return "2"
+	case occupants.Int64 <= 4:
+		return "3-4"
+	default:
+		return "5+"
+	}
+}
+
+// percentile dengan interpolasi linear; values harus sudah urut
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+// userMonthlyKwh: kWh user pada [from, to), memakai data sensor kalau ada (sama dengan /statistics/series)
+func userMonthlyKwh(userID int, records []applianceRecord, from, to time.Time) float64 {
+	points := buildUsageSeries(records, loadMeasuredUsage(userID, from, to, from.Location()), BillingProfile{Region: fallbackRegionFees}, from, to, "month")
+	total := 0.0
+	for _, point := range points {
+		total += point.Kwh
+	}
+	return total
+}
+
+// benchmarkProfile: dimensi kohort milik satu user pada suatu periode
+type benchmarkProfile struct {
+	Capacity, Region, Occupants string
+}
+
+func (p benchmarkProfile) key(level int) [3]string {
+	l := benchmarkLevels[level]
+	key := [3]string{benchmarkAny, benchmarkAny, benchmarkAny}
+	if l.Capacity {
+		key[0] = p.Capacity
+	}
+	if l.Region {
+		key[1] = p.Region
+	}
+	if l.Occupants {
+		key[2] = p.Occupants
+	}
+	return key
+}
+
+// loadBenchmarkProfile membaca wilayah, jumlah penghuni dan kelas daya user (dari inventaris terakhir sebelum to)
+func loadBenchmarkProfile(userID int, records []applianceRecord, to time.Time) benchmarkProfile {
+	var region sql.NullString
+	var occupants sql.NullInt64
+	if err := db.DB.QueryRow("SELECT kode_wilayah, jumlah_penghuni FROM users WHERE user_id = ?", userID).Scan(&region, &occupants); err != nil {
+		log.Printf("ℹ️ loadBenchmarkProfile: profil user %d tidak terbaca: %v", userID, err)
+	}
+
+	profile := benchmarkProfile{Region: defaultRegionCode, Occupants: occupantGroup(occupants)}
+	if region.Valid && region.String != "" {
+		profile.Region = region.String
+	}
+	for _, rec := range records {
+		if rec.InputDate.Before(to) {
+			profile.Capacity = capacityClass(rec.BesarListrik)
+		}
+	}
+	return profile
+}
+
+// computeBenchmarks menghitung distribusi kWh bulanan semua user untuk periode [from, to) lalu
+// menyimpan kohort yang memenuhi k-anonymity. Data periode yang sama diganti seluruhnya.
+func computeBenchmarks(from, to time.Time) error {
+	rows, err := db.DB.Query("SELECT user_id FROM users")
+	if err != nil {
+		return err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+
+	cohorts := make(map[[3]string][]float64)
+	for _, userID := range userIDs {
+		records, err := loadApplianceRecords(userID)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+		kwh := userMonthlyKwh(userID, records, from, to)
+		profile := loadBenchmarkProfile(userID, records, to)
+		if kwh <= 0 || profile.Capacity == "" {
+			continue
+		}
+		for level := range benchmarkLevels {
+			key := profile.key(level)
+			// User tanpa jumlah penghuni hanya masuk kohort yang tidak membedakan penghuni
+			if key[2] == unknownOccupants {
+				continue
+			}
+			cohorts[key] = append(cohorts[key], kwh)
+		}
+	}
+
+	period := from.Format("2006-01")
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM benchmark_kohort WHERE periode = ?", period); err != nil {
+		return err
+	}
+	stored := 0
+	for key, values := range cohorts {
+		if len(values) < benchmarkMinCohort {
+			continue
+		}
+		sort.Float64s(values)
+		_, err := tx.Exec(`
+			INSERT INTO benchmark_kohort (periode, kelas_daya, kode_wilayah, kelompok_penghuni, jumlah_user, p10, p25, median, p75, p90)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			period, key[0], key[1], key[2], len(values),
+			roundTo(percentile(values, 10), 2), roundTo(percentile(values, 25), 2), roundTo(percentile(values, 50), 2),
+			roundTo(percentile(values, 75), 2), roundTo(percentile(values, 90), 2))
+		if err != nil {
+			return err
+		}
+		stored++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("✅ [BENCHMARK] Periode %s: %d kohort disimpan dari %d user", period, stored, len(userIDs))
+	return nil
+}
+
+// StartBenchmarkScheduler menghitung ulang benchmark bulan lalu saat start lalu setiap interval
+func StartBenchmarkScheduler(interval time.Duration) {
+	run := func() {
+		now := time.Now()
+		to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		if err := computeBenchmarks(to.AddDate(0, -1, 0), to); err != nil {
+			log.Printf("❌ [BENCHMARK] Gagal menghitung benchmark: %v", err)
+		}
+	}
+	go func() {
+		log.Printf("⏰ Perhitungan benchmark dimulai, setiap %v...", interval)
+		run()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// loadCohort mengambil kohort periode tertentu; ok=false kalau tidak ada (terlalu kecil atau belum dihitung)
+func loadCohort(period string, key [3]string) (BenchmarkCohort, bool) {
+	cohort := BenchmarkCohort{Capacity: key[0], Region: key[1], Occupants: key[2]}
+	err := db.DB.QueryRow(`
+		SELECT jumlah_user, p10, p25, median, p75, p90 FROM benchmark_kohort
+		WHERE periode = ? AND kelas_daya = ? AND kode_wilayah = ? AND kelompok_penghuni = ? AND jumlah_user >= ?`,
+		period, key[0], key[1], key[2], benchmarkMinCohort).
+		Scan(&cohort.Households, &cohort.P10, &cohort.P25, &cohort.Median, &cohort.P75, &cohort.P90)
+	return cohort, err == nil
+}
+
+// estimatePercentile memperkirakan posisi user dari kuantil yang tersimpan (data mentah user lain tidak disimpan)
+func estimatePercentile(kwh float64, cohort BenchmarkCohort) (float64, string) {
+	points := []struct{ P, V float64 }{
+		{10, cohort.P10}, {25, cohort.P25}, {50, cohort.Median}, {75, cohort.P75}, {90, cohort.P90},
+	}
+	if kwh < points[0].V {
+		return 5, "below_p10"
+	}
+	bands := []string{"p10_p25", "p25_p50", "p50_p75", "p75_p90"}
+	for i := 1; i < len(points); i++ {
+		if kwh <= points[i].V {
+			low, high := points[i-1], points[i]
+			pct := high.P
+			if high.V > low.V {
+				pct = low.P + (kwh-low.V)/(high.V-low.V)*(high.P-low.P)
+			}
+			return pct, bands[i-1]
+		}
+	}
+	return 95, "above_p90"
+}
+
+func explainBenchmark(response BenchmarkResponse) string {
+	if response.Cohort == nil {
+		return "Belum cukup rumah tangga serupa untuk dibandingkan."
+	}
+	if response.MonthlyKwh <= 0 {
+		return "Belum ada data pemakaian pada periode ini."
+	}
+	peers := fmt.Sprintf("%d rumah tangga dengan daya %s", response.Cohort.Households, response.Cohort.Capacity)
+	if response.Cohort.Capacity == benchmarkAny {
+		peers = fmt.Sprintf("%d rumah tangga pengguna lain", response.Cohort.Households)
+	}
+	if response.Cohort.Occupants != benchmarkAny {
+		peers += fmt.Sprintf(" dan %s penghuni", response.Cohort.Occupants)
+	}
+
+	diff := 0.0
+	if response.VsMedianPct != nil {
+		diff = *response.VsMedianPct
+	}
+	switch {
+	case diff <= -10:
+		return fmt.Sprintf("Pemakaian Anda %.0f%% lebih hemat dari rata-rata (median) %s.", -diff, peers)
+	case diff >= 10:
+		return fmt.Sprintf("Pemakaian Anda %.0f%% lebih tinggi dari rata-rata (median) %s.", diff, peers)
+	default:
+		return fmt.Sprintf("Pemakaian Anda setara dengan rata-rata (median) %s.", peers)
+	}
+}
+
+// BenchmarkHandler: posisi pemakaian user dibanding rumah tangga serupa (GET /benchmark?period=YYYY-MM)
+func BenchmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Default: periode terbaru yang sudah dihitung job benchmark
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		var latest sql.NullString
+		if err := db.DB.QueryRow("SELECT MAX(periode) FROM benchmark_kohort").Scan(&latest); err != nil || !latest.Valid {
+			http.Error(w, `{"error": "Benchmark belum tersedia"}`, http.StatusNotFound)
+			return
+		}
+		period = latest.String
+	}
+	from, err := time.ParseInLocation("2006-01", period, time.Local)
+	if err != nil {
+		http.Error(w, `{"error": "Format period tidak valid, gunakan YYYY-MM"}`, http.StatusBadRequest)
+		return
+	}
+	to := from.AddDate(0, 1, 0)
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ BenchmarkHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, `{"error": "Belum ada data perangkat"}`, http.StatusNotFound)
+		return
+	}
+
+	profile := loadBenchmarkProfile(userID, records, to)
+	response := BenchmarkResponse{
+		Period:     period,
+		MonthlyKwh: roundTo(userMonthlyKwh(userID, records, from, to), 2),
+		Capacity:   profile.Capacity,
+		Region:     profile.Region,
+		Occupants:  profile.Occupants,
+	}
+
+	for level := range benchmarkLevels {
+		key := profile.key(level)
+		if key[2] == unknownOccupants || (key[0] == "" && benchmarkLevels[level].Capacity) {
+			continue
+		}
+		if cohort, found := loadCohort(period, key); found {
+			cohort.Level = benchmarkLevels[level].Name
+			response.Cohort = &cohort
+			break
+		}
+	}
+
+	if response.Cohort != nil && response.MonthlyKwh > 0 {
+		pct, band := estimatePercentile(response.MonthlyKwh, *response.Cohort)
+		pct = roundTo(pct, 0)
+		response.Percentile = &pct
+		response.Band = band
+		response.VsMedianPct = percentChange(response.MonthlyKwh, response.Cohort.Median)
+	}
+	response.Explanation = explainBenchmark(response)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// billAccuracyTolerance: selisih perkiraan vs tagihan dalam ±10% dianggap akurat
+const billAccuracyTolerance = 0.10
+
+type ElectricityBill struct {
+	Period string   `json:"period"` // YYYY-MM
+	Kwh    *float64 `json:"kwh"`
+	Amount *float64 `json:"amount"`
+	Source string   `json:"source"`
+}
+
+type CategoryGap struct {
+	CategoryID          int     `json:"category_id"`
+	CategoryName        string  `json:"category_name"`
+	EstimatedKwh        float64 `json:"estimated_kwh"`
+	SharePercent        float64 `json:"share_percent"`
+	AttributedGapKwh    float64 `json:"attributed_gap_kwh"`
+	LikelyUnderEstimate bool    `json:"likely_under_estimated"`
+}
+
+type BillReconciliation struct {
+	Period          string        `json:"period"`
+	ActualKwh       float64       `json:"actual_kwh"`
+	ActualKwhSource string        `json:"actual_kwh_source"` // "bill", "derived_from_amount", atau "unknown" (dibandingkan dalam Rp)
+	ActualAmount    *float64      `json:"actual_amount"`
+	EstimatedKwh    float64       `json:"estimated_kwh"`
+	EstimatedAmount float64       `json:"estimated_amount"`
+	MeasuredKwh     *float64      `json:"measured_kwh"`
+	GapKwh          float64       `json:"gap_kwh"`
+	GapPercent      float64       `json:"gap_percent"`
+	GapAmount       *float64      `json:"gap_amount"`
+	Status          string        `json:"status"`
+	IDSubmit        string        `json:"id_submit"`
+	Backfilled      bool          `json:"backfilled"`
+	Categories      []CategoryGap `json:"categories"`
+}
+
+// previousBillingPeriod: tagihan pascabayar yang dibayar bulan ini adalah pemakaian bulan lalu
+func previousBillingPeriod(now time.Time) string {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0).Format("2006-01")
+}
+
+// saveBill menyimpan (atau menimpa) tagihan satu periode
+func saveBill(execer sqlExecer, userID int, bill ElectricityBill) error {
+	var kwh, amount interface{}
+	if bill.Kwh != nil {
+		kwh = *bill.Kwh
+	}
+	if bill.Amount != nil {
+		amount = *bill.Amount
+	}
+	_, err := execer.Exec(`
+		INSERT INTO tagihan_listrik (user_id, periode, kwh, jumlah_rp, sumber)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE kwh = VALUES(kwh), jumlah_rp = VALUES(jumlah_rp), sumber = VALUES(sumber)`,
+		userID, bill.Period, kwh, amount, bill.Source)
+	return err
+}
+
+func loadBills(userID int, period string) ([]ElectricityBill, error) {
+	query := `SELECT periode, kwh, jumlah_rp, sumber FROM tagihan_listrik WHERE user_id = ?`
+	args := []interface{}{userID}
+	if period != "" {
+		query += " AND periode = ?"
+		args = append(args, period)
+	}
+	query += " ORDER BY periode DESC"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bills := []ElectricityBill{}
+	for rows.Next() {
+		var bill ElectricityBill
+		var kwh, amount sql.NullFloat64
+		if err := rows.Scan(&bill.Period, &kwh, &amount, &bill.Source); err != nil {
+			return nil, err
+		}
+		if kwh.Valid {
+			bill.Kwh = &kwh.Float64
+		}
+		if amount.Valid {
+			bill.Amount = &amount.Float64
+		}
+		bills = append(bills, bill)
+	}
+	return bills, rows.Err()
+}
+
+// reconcileBill membandingkan satu tagihan dengan perkiraan dari riwayat_perangkat dan energy_logs
+func reconcileBill(userID int, bill ElectricityBill, timeline inventoryTimeline, profile BillingProfile) BillReconciliation {
+	start, _ := time.Parse("2006-01", bill.Period)
+	end := start.AddDate(0, 1, 0)
+	result := BillReconciliation{Period: bill.Period, ActualAmount: bill.Amount, Categories: []CategoryGap{}}
+
+	// Perkiraan harian per kategori selama satu periode
+	byCategory := make(map[int]*CategoryGap)
+	besarListrik := ""
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		batch, ok := timeline.activeOn(day, true)
+		if !ok {
+			continue
+		}
+		result.IDSubmit = batch.IDSubmit
+		if batch.Start > day.Format("2006-01-02") {
+			result.Backfilled = true
+		}
+		for _, rec := range batch.Records {
+			kwh := rec.dailyKWhOn(day.Weekday())
+			result.EstimatedKwh += kwh
+			besarListrik = rec.BesarListrik
+
+			categoryID := 0
+			if rec.CategoryID != nil {
+				categoryID = *rec.CategoryID
+			}
+			category, exists := byCategory[categoryID]
+			if !exists {
+				name := rec.CategoryName
+				if categoryID == 0 || name == "" {
+					name = uncategorizedLabel
+				}
+				category = &CategoryGap{CategoryID: categoryID, CategoryName: name}
+				byCategory[categoryID] = category
+			}
+			category.EstimatedKwh += kwh
+		}
+	}
+
+	// Nominal perkiraan dihitung lengkap (PPJ, admin, materai) supaya sebanding dengan rekening (selalu pascabayar)
+	profile.Prepaid = false
+	result.EstimatedAmount = calculateBill(result.EstimatedKwh, besarListrik, profile, start).Total
+
+	// kWh aktual: dari tagihan, atau dihitung balik dari nominal kalau kWh tidak diisi.
+	// Kalau tidak bisa dihitung balik (mis. kena rekening minimum), perbandingan memakai Rp.
+	result.ActualKwhSource = "unknown"
+	switch {
+	case bill.Kwh != nil:
+		result.ActualKwh, result.ActualKwhSource = *bill.Kwh, "bill"
+	case bill.Amount != nil:
+		if kwh, ok := kwhFromBillTotal(*bill.Amount, besarListrik, profile, start); ok {
+			result.ActualKwh, result.ActualKwhSource = kwh, "derived_from_amount"
+		}
+	}
+
+	if measured, ok := measuredKWhBetween(userID, start, end); ok {
+		measured = roundTo(measured, 2)
+		result.MeasuredKwh = &measured
+	}
+
+	gap := 0.0
+	if result.ActualKwhSource != "unknown" {
+		gap = result.ActualKwh - result.EstimatedKwh
+	}
+	result.GapKwh = roundTo(gap, 2)
+	if result.EstimatedKwh > 0 {
+		result.GapPercent = roundTo(gap/result.EstimatedKwh*100, 1)
+	}
+	if bill.Amount != nil {
+		gapAmount := roundTo(*bill.Amount-result.EstimatedAmount, 0)
+		result.GapAmount = &gapAmount
+	}
+
+	// Pembanding status: kWh kalau diketahui, selain itu nominal rekening
+	estimated, diff := result.EstimatedKwh, gap
+	if result.ActualKwhSource == "unknown" {
+		estimated, diff = result.EstimatedAmount, 0
+		if result.GapAmount != nil {
+			diff = *result.GapAmount
+		}
+	}
+	switch {
+	case result.EstimatedKwh == 0:
+		result.Status = "no_estimate"
+	case diff > estimated*billAccuracyTolerance:
+		result.Status = "under_estimated"
+	case -diff > estimated*billAccuracyTolerance:
+		result.Status = "over_estimated"
+	default:
+		result.Status = "accurate"
+	}
+
+	// Selisih dibagi proporsional ke kategori sesuai porsi perkiraannya; kategori terbesar
+	// yang menanggung selisih positif paling mungkin durasi/dayanya diisi terlalu kecil.
+	for _, category := range byCategory {
+		share := 0.0
+		if result.EstimatedKwh > 0 {
+			share = category.EstimatedKwh / result.EstimatedKwh
+		}
+		category.SharePercent = roundTo(share*100, 1)
+		category.AttributedGapKwh = roundTo(gap*share, 2)
+		category.LikelyUnderEstimate = result.Status == "under_estimated" && share >= 0.1
+		category.EstimatedKwh = roundTo(category.EstimatedKwh, 2)
+		result.Categories = append(result.Categories, *category)
+	}
+	sort.Slice(result.Categories, func(i, j int) bool {
+		return result.Categories[i].AttributedGapKwh > result.Categories[j].AttributedGapKwh
+	})
+
+	result.EstimatedKwh = roundTo(result.EstimatedKwh, 2)
+	result.ActualKwh = roundTo(result.ActualKwh, 2)
+	return result
+}
+
+// BillsHandler: GET daftar tagihan, POST simpan tagihan satu periode, DELETE ?period= hapus tagihan
+func BillsHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bills, err := loadBills(userID, "")
+		if err != nil {
+			log.Printf("❌ BillsHandler: Error loading bills: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data tagihan"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bills)
+
+	case http.MethodPost:
+		var bill ElectricityBill
+		if err := json.NewDecoder(r.Body).Decode(&bill); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if bill.Period == "" {
+			bill.Period = previousBillingPeriod(time.Now())
+		}
+		if _, err := time.Parse("2006-01", bill.Period); err != nil {
+			http.Error(w, `{"error": "Format period harus YYYY-MM"}`, http.StatusBadRequest)
+			return
+		}
+		if (bill.Kwh == nil || *bill.Kwh <= 0) && (bill.Amount == nil || *bill.Amount <= 0) {
+			http.Error(w, `{"error": "kWh atau nominal tagihan wajib diisi"}`, http.StatusBadRequest)
+			return
+		}
+		bill.Source = "manual"
+
+		if err := saveBill(db.DB, userID, bill); err != nil {
+			log.Printf("❌ BillsHandler: Gagal menyimpan tagihan: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan tagihan"}`, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✅ Tagihan periode %s user %d disimpan", bill.Period, userID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bill)
+
+	case http.MethodDelete:
+		period := r.URL.Query().Get("period")
+		result, err := db.DB.Exec("DELETE FROM tagihan_listrik WHERE user_id = ? AND periode = ?", userID, period)
+		if err != nil {
+			log.Printf("❌ BillsHandler: Gagal menghapus tagihan: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus tagihan"}`, http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, `{"error": "Tagihan tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Tagihan berhasil dihapus", "period": period})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// BillReconciliationHandler membandingkan tagihan asli dengan perkiraan aplikasi.
+// ?period=YYYY-MM untuk satu periode; tanpa parameter, semua periode (terbaru di atas).
+func BillReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period != "" {
+		if _, err := time.Parse("2006-01", period); err != nil {
+			http.Error(w, `{"error": "Format period harus YYYY-MM"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	bills, err := loadBills(userID, period)
+	if err != nil {
+		log.Printf("❌ BillReconciliationHandler: Error loading bills: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data tagihan"}`, http.StatusInternalServerError)
+		return
+	}
+	if period != "" && len(bills) == 0 {
+		http.Error(w, `{"error": "Tagihan untuk periode ini belum ada"}`, http.StatusNotFound)
+		return
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ BillReconciliationHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	timeline := buildInventoryTimeline(records)
+	profile := userBillingProfile(userID)
+
+	reports := make([]BillReconciliation, 0, len(bills))
+	for _, bill := range bills {
+		reports = append(reports, reconcileBill(userID, bill, timeline, profile))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if period != "" {
+		json.NewEncoder(w).Encode(reports[0])
+		return
+	}
+	json.NewEncoder(w).Encode(reports)
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultRegionCode: wilayah yang dipakai kalau user belum memilih wilayah
+const defaultRegionCode = "DEFAULT"
+
+// RegionFees: komponen tagihan yang berbeda per wilayah (PPJ diatur Perda masing-masing daerah)
+type RegionFees struct {
+	Code              string  `json:"kode_wilayah"`
+	Name              string  `json:"nama_wilayah"`
+	PPJPercent        float64 `json:"ppj_persen"`
+	AdminFee          float64 `json:"biaya_admin"`
+	StampDuty         float64 `json:"bea_materai"`
+	StampDutyMinTotal float64 `json:"materai_min_tagihan"`
+	MinimumOnHours    float64 `json:"jam_nyala_min"`
+}
+
+// fallbackRegionFees dipakai kalau tabel wilayah_tarif kosong atau tidak terbaca
+var fallbackRegionFees = RegionFees{
+	Code: defaultRegionCode, Name: "Default", PPJPercent: 3, AdminFee: 2500,
+	StampDuty: 10000, StampDutyMinTotal: 5000000, MinimumOnHours: 40,
+}
+
+// BillBreakdown: rincian tagihan bulanan seperti di rekening PLN
+type BillBreakdown struct {
+	Kwh            float64 `json:"kwh"`
+	BilledKwh      float64 `json:"billed_kwh"`
+	MinimumKwh     float64 `json:"minimum_kwh"`
+	MinimumApplied bool    `json:"minimum_applied"`
+	TariffPerKwh   float64 `json:"tariff_per_kwh"`
+	EnergyCharge   float64 `json:"energy_charge"`
+	PPJPercent     float64 `json:"ppj_percent"`
+	PPJ            float64 `json:"ppj"`
+	AdminFee       float64 `json:"admin_fee"`
+	StampDuty      float64 `json:"stamp_duty"`
+	Total          float64 `json:"total"`
+	RegionCode     string  `json:"region_code"`
+	Prepaid        bool    `json:"prepaid"`
+}
+
+// BillingProfile: komponen tagihan yang bergantung pada user (lihat userBillingProfile)
+type BillingProfile struct {
+	Region     RegionFees
+	Prepaid    bool
+	Subsidized bool
+}
+
+// calculateBill menghitung tagihan satu bulan dari pemakaian kWh.
+// Pascabayar kena rekening minimum (jam nyala x kVA); prabayar tidak, dan bea materai hanya untuk rekening pascabayar.
+func calculateBill(kwh float64, besarListrik string, profile BillingProfile, at time.Time) BillBreakdown {
+	return calculateBillAtRate(kwh, besarListrik, tariffPerKwhAt(besarListrik, profile.Subsidized, at), profile.Region, profile.Prepaid)
+}
+
+// calculateBillAtRate sama dengan calculateBill tapi tarif per kWh ditentukan pemanggil (misal tarif bersubsidi)
+func calculateBillAtRate(kwh float64, besarListrik string, tariff float64, region RegionFees, prepaid bool) BillBreakdown {
+	bill := BillBreakdown{
+		Kwh:          roundTo(kwh, 2),
+		BilledKwh:    kwh,
+		TariffPerKwh: tariff,
+		PPJPercent:   region.PPJPercent,
+		AdminFee:     region.AdminFee,
+		RegionCode:   region.Code,
+		Prepaid:      prepaid,
+	}
+
+	if !prepaid {
+		if va, ok := parseCapacityVA(besarListrik); ok {
+			bill.MinimumKwh = roundTo(region.MinimumOnHours*float64(va)/1000.0, 2)
+			if kwh < bill.MinimumKwh {
+				bill.BilledKwh = bill.MinimumKwh
+				bill.MinimumApplied = true
+			}
+		}
+	}
+
+	bill.EnergyCharge = roundTo(bill.BilledKwh*bill.TariffPerKwh, 0)
+	bill.PPJ = roundTo(bill.EnergyCharge*region.PPJPercent/100, 0)
+	bill.Total = bill.EnergyCharge + bill.PPJ + bill.AdminFee
+	if !prepaid && region.StampDuty > 0 && bill.Total >= region.StampDutyMinTotal {
+		bill.StampDuty = region.StampDuty
+		bill.Total += bill.StampDuty
+	}
+	bill.BilledKwh = roundTo(bill.BilledKwh, 2)
+	return bill
+}
+
+// kwhFromBillTotal kebalikan calculateBill: kWh dari nominal rekening (dikurangi materai dan admin, dibagi 1+PPJ, lalu tarif).
+// Rekening pascabayar di bawah atau sama dengan rekening minimum tidak bisa dihitung balik (kWh sebenarnya tidak diketahui).
+func kwhFromBillTotal(total float64, besarListrik string, profile BillingProfile, at time.Time) (float64, bool) {
+	tariff := tariffPerKwhAt(besarListrik, profile.Subsidized, at)
+	if tariff <= 0 {
+		return 0, false
+	}
+	region := profile.Region
+	if !profile.Prepaid && region.StampDuty > 0 && total-region.StampDuty >= region.StampDutyMinTotal {
+		total -= region.StampDuty
+	}
+	energyCharge := (total - region.AdminFee) / (1 + region.PPJPercent/100)
+	kwh := energyCharge / tariff
+	if kwh <= 0 {
+		return 0, false
+	}
+	if !profile.Prepaid {
+		// Dibulatkan 2 desimal seperti MinimumKwh, supaya pembulatan Rp di tagihan minimum tidak lolos
+		if va, ok := parseCapacityVA(besarListrik); ok && roundTo(kwh, 2) <= roundTo(region.MinimumOnHours*float64(va)/1000.0, 2) {
+			return 0, false
+		}
+	}
+	return kwh, true
+}
+
+func loadRegionFees(code string) (RegionFees, bool) {
+	var region RegionFees
+	err := db.DB.QueryRow(`
+		SELECT kode_wilayah, nama_wilayah, ppj_persen, biaya_admin, bea_materai, materai_min_tagihan, jam_nyala_min
+		FROM wilayah_tarif WHERE kode_wilayah = ?`, code).
+		Scan(&region.Code, &region.Name, &region.PPJPercent, &region.AdminFee,
+			&region.StampDuty, &region.StampDutyMinTotal, &region.MinimumOnHours)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("❌ loadRegionFees: Error querying wilayah %s: %v", code, err)
+		}
+		return RegionFees{}, false
+	}
+	return region, true
+}
+
+// userBillingProfile: wilayah user (fallback ke DEFAULT), status subsidi dari profil, dan apakah user
+// pelanggan prabayar (dilihat dari jenis pembayaran di submit terakhir)
+func userBillingProfile(userID int) BillingProfile {
+	var code sql.NullString
+	var subsidized bool
+	if err := db.DB.QueryRow("SELECT kode_wilayah, subsidi FROM users WHERE user_id = ?", userID).Scan(&code, &subsidized); err != nil {
+		log.Printf("ℹ️ userBillingProfile: profil tagihan user %d tidak terbaca: %v", userID, err)
+	}
+
+	region, ok := RegionFees{}, false
+	if code.Valid && code.String != "" {
+		region, ok = loadRegionFees(code.String)
+	}
+	if !ok {
+		region, ok = loadRegionFees(defaultRegionCode)
+	}
+	if !ok {
+		region = fallbackRegionFees
+	}
+
+	var billingType string
+	db.DB.QueryRow(`
+		SELECT COALESCE(Jenis_Pembayaran, '') FROM riwayat_perangkat
+		WHERE user_id = ? AND deleted_at IS NULL
+		ORDER BY tanggal_input DESC, id DESC LIMIT 1`, userID).Scan(&billingType)
+	return BillingProfile{Region: region, Prepaid: isPrepaidBilling(billingType), Subsidized: subsidized}
+}
+
+// monthlyBillForUser: shortcut menghitung tagihan bulanan user dari kWh per bulan
+func monthlyBillForUser(userID int, monthlyKwh float64, besarListrik string) BillBreakdown {
+	return calculateBill(monthlyKwh, besarListrik, userBillingProfile(userID), time.Now())
+}
+
+// RegionsHandler menampilkan daftar wilayah yang bisa dipilih user di profil
+func RegionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT kode_wilayah, nama_wilayah, ppj_persen, biaya_admin, bea_materai, materai_min_tagihan, jam_nyala_min
+		FROM wilayah_tarif ORDER BY nama_wilayah`)
+	if err != nil {
+		log.Printf("❌ RegionsHandler: Error querying regions: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data wilayah"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	regions := []RegionFees{}
+	for rows.Next() {
+		var region RegionFees
+		if err := rows.Scan(&region.Code, &region.Name, &region.PPJPercent, &region.AdminFee,
+			&region.StampDuty, &region.StampDutyMinTotal, &region.MinimumOnHours); err != nil {
+			log.Printf("❌ RegionsHandler: Error scanning region: %v", err)
+			http.Error(w, `{"error": "Gagal membaca data wilayah"}`, http.StatusInternalServerError)
+			return
+		}
+		regions = append(regions, region)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(regions)
+}
+
+// AdminRegionsHandler: POST/PUT simpan komponen tagihan satu wilayah, DELETE ?kode= hapus wilayah.
+// Wajib header X-Admin-Token.
+func AdminRegionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		RegionsHandler(w, r)
+
+	case http.MethodPost, http.MethodPut:
+		var region RegionFees
+		if err := json.NewDecoder(r.Body).Decode(&region); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		region.Code = strings.ToUpper(strings.TrimSpace(region.Code))
+		if region.Code == "" || strings.TrimSpace(region.Name) == "" {
+			http.Error(w, `{"error": "Kode dan nama wilayah wajib diisi"}`, http.StatusBadRequest)
+			return
+		}
+		if region.PPJPercent < 0 || region.PPJPercent > 100 || region.AdminFee < 0 || region.StampDuty < 0 ||
+			region.StampDutyMinTotal < 0 || region.MinimumOnHours < 0 {
+			http.Error(w, `{"error": "Nilai komponen tagihan tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+
+		_, err := db.DB.Exec(`
+			INSERT INTO wilayah_tarif (kode_wilayah, nama_wilayah, ppj_persen, biaya_admin, bea_materai, materai_min_tagihan, jam_nyala_min)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE nama_wilayah = VALUES(nama_wilayah), ppj_persen = VALUES(ppj_persen),
+				biaya_admin = VALUES(biaya_admin), bea_materai = VALUES(bea_materai),
+				materai_min_tagihan = VALUES(materai_min_tagihan), jam_nyala_min = VALUES(jam_nyala_min)`,
+			region.Code, region.Name, region.PPJPercent, region.AdminFee, region.StampDuty,
+			region.StampDutyMinTotal, region.MinimumOnHours)
+		if err != nil {
+			log.Printf("❌ AdminRegionsHandler: Gagal menyimpan wilayah: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan wilayah"}`, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✅ Wilayah %s (PPJ %.2f%%) disimpan", region.Code, region.PPJPercent)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(region)
+
+	case http.MethodDelete:
+		code := strings.ToUpper(r.URL.Query().Get("kode"))
+		if code == defaultRegionCode {
+			http.Error(w, `{"error": "Wilayah DEFAULT tidak boleh dihapus"}`, http.StatusBadRequest)
+			return
+		}
+		result, err := db.DB.Exec("DELETE FROM wilayah_tarif WHERE kode_wilayah = ?", code)
+		if err != nil {
+			log.Printf("❌ AdminRegionsHandler: Gagal menghapus wilayah: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus wilayah"}`, http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, `{"error": "Wilayah tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Wilayah berhasil dihapus", "kode_wilayah": code})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// GetBillEstimateHandler: perkiraan rekening bulan ini lengkap dengan PPJ, admin, dan materai
+func GetBillEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ GetBillEstimateHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, `{"error": "Belum ada data perangkat"}`, http.StatusNotFound)
+		return
+	}
+
+	// Pemakaian sebulan penuh (bulan berjalan) mengikuti inventaris yang aktif tiap hari
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	timeline := buildInventoryTimeline(records)
+	monthlyKwh := 0.0
+	besarListrik := ""
+	for day := monthStart; day.Month() == monthStart.Month(); day = day.AddDate(0, 0, 1) {
+		batch, ok := timeline.activeOn(day, true)
+		if !ok {
+			continue
+		}
+		monthlyKwh += batch.estimatedKWhOn(day)
+		if len(batch.Records) > 0 {
+			besarListrik = batch.Records[0].BesarListrik
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period":        monthStart.Format("2006-01"),
+		"besar_listrik": besarListrik,
+		"bill":          calculateBill(monthlyKwh, besarListrik, userBillingProfile(userID), now),
+	})
+}
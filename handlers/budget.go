@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	firebase "firebase.google.com/go"
+)
+
+// defaultBudgetThresholds: peringatan dikirim saat proyeksi akhir bulan mencapai persen ini
+var defaultBudgetThresholds = []int{80, 100}
+
+type Budget struct {
+	LimitRp    float64 `json:"limit_rp"`
+	Thresholds []int   `json:"thresholds"`
+	Active     bool    `json:"active"`
+}
+
+type BudgetStatus struct {
+	Period           string   `json:"period"`
+	LimitRp          float64  `json:"limit_rp"`
+	SpentRp          float64  `json:"spent_rp"`
+	SpentKwh         float64  `json:"spent_kwh"`
+	ProjectedRp      float64  `json:"projected_rp"`
+	ProjectedKwh     float64  `json:"projected_kwh"`
+	UsedPercent      float64  `json:"used_percent"`
+	ProjectedPercent float64  `json:"projected_percent"`
+	Source           string   `json:"source"` // "iot", "mixed" (terukur + sisa bulan dari perkiraan), atau "estimated"
+	DaysElapsed      int      `json:"days_elapsed"`
+	DaysInMonth      int      `json:"days_in_month"`
+	CrossedThreshold []int    `json:"crossed_thresholds"`
+	DailyAllowanceRp *float64 `json:"daily_allowance_rp"`
+}
+
+func parseThresholds(value string) []int {
+	var thresholds []int
+	for _, part := range strings.Split(value, ",") {
+		if threshold, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && threshold > 0 {
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	if len(thresholds) == 0 {
+		return defaultBudgetThresholds
+	}
+	return thresholds
+}
+
+func formatThresholds(thresholds []int) string {
+	parts := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		parts[i] = strconv.Itoa(threshold)
+	}
+	return strings.Join(parts, ",")
+}
+
+func loadBudget(userID int) (*Budget, error) {
+	var budget Budget
+	var thresholds string
+	err := db.DB.QueryRow(`
+		SELECT batas_rp, ambang_persen, aktif FROM anggaran WHERE user_id = ?`, userID).
+		Scan(&budget.LimitRp, &thresholds, &budget.Active)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	budget.Thresholds = parseThresholds(thresholds)
+	return &budget, nil
+}
+
+// buildBudgetStatus menghitung pengeluaran bulan berjalan dan proyeksi akhir bulan.
+// Data IoT (energy_logs) dipakai kalau ada; selain itu perkiraan dari inventaris perangkat.
+func buildBudgetStatus(userID int, budget Budget, now time.Time) (BudgetStatus, error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	status := BudgetStatus{
+		Period:           monthStart.Format("2006-01"),
+		LimitRp:          budget.LimitRp,
+		DaysElapsed:      now.Day(),
+		DaysInMonth:      monthEnd.AddDate(0, 0, -1).Day(),
+		CrossedThreshold: []int{},
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		return status, err
+	}
+	timeline := buildInventoryTimeline(records)
+
+	// Perkiraan per hari dari inventaris; hari ini dihitung sebagian sesuai jam sekarang
+	estimatedSoFar, estimatedRemaining := 0.0, 0.0
+	besarListrik := ""
+	for day := monthStart; day.Before(monthEnd); day = day.AddDate(0, 0, 1) {
+		batch, ok := timeline.activeOn(day, true)
+		if !ok {
+			continue
+		}
+		if len(batch.Records) > 0 {
+			besarListrik = batch.Records[0].BesarListrik
+		}
+		kwh := batch.estimatedKWhOn(day)
+		switch {
+		case day.Before(today):
+			estimatedSoFar += kwh
+		case day.Equal(today):
+			elapsed := now.Sub(today).Hours() / 24
+			estimatedSoFar += kwh * elapsed
+			estimatedRemaining += kwh * (1 - elapsed)
+		default:
+			estimatedRemaining += kwh
+		}
+	}
+
+	status.SpentKwh, status.ProjectedKwh, status.Source = estimatedSoFar, estimatedSoFar+estimatedRemaining, "estimated"
+	if measured, ok := measuredKWhBetween(userID, monthStart, now); ok && measured > 0 {
+		status.SpentKwh = measured
+		// Sisa bulan diproyeksikan dari rata-rata harian terukur (butuh data minimal minMeasuredSpan);
+		// di awal bulan atau sensor baru, beberapa jam data tidak cukup jadi sisa bulan tetap dari perkiraan
+		if daily, ok := measuredDailyKWh(userID, now.AddDate(0, 0, -measuredWindowDays)); ok {
+			status.ProjectedKwh = measured + daily*monthEnd.Sub(now).Hours()/24
+			status.Source = "iot"
+		} else {
+			status.ProjectedKwh = measured + estimatedRemaining
+			status.Source = "mixed"
+		}
+	}
+
+	// Rupiah mengikuti rekening lengkap (PPJ, admin, materai); pengeluaran berjalan diambil proporsional
+	bill := calculateBill(status.ProjectedKwh, besarListrik, userBillingProfile(userID), now)
+	status.ProjectedRp = bill.Total
+	if status.ProjectedKwh > 0 {
+		status.SpentRp = roundTo(bill.Total*status.SpentKwh/status.ProjectedKwh, 0)
+	}
+	status.SpentKwh = roundTo(status.SpentKwh, 2)
+	status.ProjectedKwh = roundTo(status.ProjectedKwh, 2)
+
+	if budget.LimitRp > 0 {
+		status.UsedPercent = roundTo(status.SpentRp/budget.LimitRp*100, 1)
+		status.ProjectedPercent = roundTo(status.ProjectedRp/budget.LimitRp*100, 1)
+		for _, threshold := range budget.Thresholds {
+			if status.ProjectedPercent >= float64(threshold) {
+				status.CrossedThreshold = append(status.CrossedThreshold, threshold)
+			}
+		}
+
+		// Sisa anggaran dibagi sisa hari (termasuk hari ini)
+		remainingDays := status.DaysInMonth - now.Day() + 1
+		allowance := roundTo((budget.LimitRp-status.SpentRp)/float64(remainingDays), 0)
+		if allowance < 0 {
+			allowance = 0
+		}
+		status.DailyAllowanceRp = &allowance
+	}
+	return status, nil
+}
+
+// BudgetHandler: GET anggaran bulanan, PUT/POST atur anggaran, DELETE hapus anggaran
+func BudgetHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		budget, err := loadBudget(userID)
+		if err != nil {
+			log.Printf("❌ BudgetHandler: Error loading budget: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil anggaran"}`, http.StatusInternalServerError)
+			return
+		}
+		if budget == nil {
+			http.Error(w, `{"error": "Anggaran belum diatur"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(budget)
+
+	case http.MethodPut, http.MethodPost:
+		var input struct {
+			LimitRp    float64 `json:"limit_rp"`
+			Thresholds []int   `json:"thresholds"`
+			Active     *bool   `json:"active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if input.LimitRp <= 0 {
+			http.Error(w, `{"error": "Batas anggaran harus lebih besar dari 0"}`, http.StatusBadRequest)
+			return
+		}
+		budget := Budget{LimitRp: input.LimitRp, Thresholds: defaultBudgetThresholds, Active: true}
+		if len(input.Thresholds) > 0 {
+			for _, threshold := range input.Thresholds {
+				if threshold <= 0 || threshold > 200 {
+					http.Error(w, `{"error": "Ambang peringatan harus antara 1 dan 200 persen"}`, http.StatusBadRequest)
+					return
+				}
+			}
+			budget.Thresholds = append([]int{}, input.Thresholds...)
+			sort.Ints(budget.Thresholds)
+		}
+		if input.Active != nil {
+			budget.Active = *input.Active
+		}
+
+		_, err := db.DB.Exec(`
+			INSERT INTO anggaran (user_id, batas_rp, ambang_persen, aktif) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE batas_rp = VALUES(batas_rp), ambang_persen = VALUES(ambang_persen), aktif = VALUES(aktif)`,
+			userID, budget.LimitRp, formatThresholds(budget.Thresholds), budget.Active)
+		if err != nil {
+			log.Printf("❌ BudgetHandler: Gagal menyimpan anggaran: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan anggaran"}`, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✅ Anggaran user %d diatur Rp %.0f (ambang %v)", userID, budget.LimitRp, budget.Thresholds)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(budget)
+
+	case http.MethodDelete:
+		if _, err := db.DB.Exec("DELETE FROM anggaran WHERE user_id = ?", userID); err != nil {
+			log.Printf("❌ BudgetHandler: Gagal menghapus anggaran: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus anggaran"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Anggaran berhasil dihapus"})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// BudgetStatusHandler: pengeluaran bulan ini vs anggaran, plus proyeksi akhir bulan
+func BudgetStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	budget, err := loadBudget(userID)
+	if err != nil {
+		log.Printf("❌ BudgetStatusHandler: Error loading budget: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil anggaran"}`, http.StatusInternalServerError)
+		return
+	}
+	if budget == nil {
+		http.Error(w, `{"error": "Anggaran belum diatur"}`, http.StatusNotFound)
+		return
+	}
+
+	status, err := buildBudgetStatus(userID, *budget, time.Now())
+	if err != nil {
+		log.Printf("❌ BudgetStatusHandler: Error building status: %v", err)
+		http.Error(w, `{"error": "Gagal menghitung status anggaran"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// checkBudgets mengirim peringatan sekali per ambang per bulan untuk setiap anggaran aktif
+func checkBudgets(app *firebase.App) {
+	rows, err := db.DB.Query(`SELECT user_id, batas_rp, ambang_persen FROM anggaran WHERE aktif = TRUE`)
+	if err != nil {
+		log.Printf("❌ [BUDGET] Gagal mengambil daftar anggaran: %v", err)
+		return
+	}
+	type activeBudget struct {
+		UserID int
+		Budget Budget
+	}
+	var budgets []activeBudget
+	for rows.Next() {
+		var item activeBudget
+		var thresholds string
+		if err := rows.Scan(&item.UserID, &item.Budget.LimitRp, &thresholds); err == nil {
+			item.Budget.Thresholds = parseThresholds(thresholds)
+			item.Budget.Active = true
+			budgets = append(budgets, item)
+		}
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, item := range budgets {
+		status, err := buildBudgetStatus(item.UserID, item.Budget, now)
+		if err != nil {
+			log.Printf("❌ [BUDGET] Gagal menghitung status anggaran user %d: %v", item.UserID, err)
+			continue
+		}
+		for _, threshold := range status.CrossedThreshold {
+			title := fmt.Sprintf("Anggaran Listrik %d%%", threshold)
+			body := fmt.Sprintf("Proyeksi tagihan bulan ini Rp %.0f (%.0f%% dari anggaran Rp %.0f).",
+				status.ProjectedRp, status.ProjectedPercent, status.LimitRp)
+			if status.UsedPercent >= float64(threshold) {
+				body = fmt.Sprintf("Pemakaian bulan ini sudah Rp %.0f (%.0f%% dari anggaran Rp %.0f).",
+					status.SpentRp, status.UsedPercent, status.LimitRp)
+			}
+			notifyUserOnce(app, item.UserID, "budget", fmt.Sprintf("%s:%d", status.Period, threshold), title, body)
+		}
+	}
+}
+
+// StartBudgetAlertScheduler memeriksa semua anggaran aktif setiap interval
+func StartBudgetAlertScheduler(app *firebase.App, interval time.Duration) {
+	go func() {
+		log.Printf("⏰ Cek anggaran bulanan dimulai, setiap %v...", interval)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkBudgets(app)
+		}
+	}()
+}
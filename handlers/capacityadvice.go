@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// connectionFeeTiers: perkiraan biaya penyambungan (BP) PLN per VA untuk tambah daya rumah tangga.
+// Tarif diambil dari golongan daya tujuan; MaxVA 0 berarti tanpa batas atas.
+var connectionFeeTiers = []struct {
+	MaxVA int
+	PerVA float64
+}{
+	{2200, 775},
+	{5500, 969},
+	{0, 1025},
+}
+
+// capacityNeighbours: berapa pilihan daya di bawah/di atas daya sekarang yang dibandingkan
+const capacityNeighbours = 2
+
+type CapacityOption struct {
+	Capacity          string        `json:"capacity"`
+	CapacityVA        int           `json:"capacity_va"`
+	Current           bool          `json:"current"`
+	Subsidized        bool          `json:"subsidized"`
+	Bill              BillBreakdown `json:"bill"`
+	MonthlyDifference float64       `json:"monthly_difference"`
+	SubsidyLoss       float64       `json:"subsidy_loss"`
+	ConnectionFee     float64       `json:"connection_fee"`
+	FirstYearCost     float64       `json:"first_year_cost"`
+	PaybackMonths     *float64      `json:"payback_months"`
+	PeakPercent       float64       `json:"peak_percent"`
+	Fits              bool          `json:"fits"`
+	Rank              int           `json:"rank"`
+	Reasons           []string      `json:"reasons"`
+}
+
+type CapacityRecommendation struct {
+	CurrentCapacity string           `json:"current_capacity"`
+	MonthlyKwh      float64          `json:"monthly_kwh"`
+	KwhSource       string           `json:"kwh_source"`
+	PeakVA          float64          `json:"peak_va"`
+	RequiredVA      float64          `json:"required_va"`
+	Recommended     string           `json:"recommended"`
+	Summary         string           `json:"summary"`
+	Options         []CapacityOption `json:"options"`
+}
+
+// connectionFee: biaya tambah daya dari fromVA ke toVA. Turun daya tidak dikenakan BP.
+func connectionFee(fromVA, toVA int) float64 {
+	if toVA <= fromVA {
+		return 0
+	}
+	for _, tier := range connectionFeeTiers {
+		if tier.MaxVA == 0 || toVA <= tier.MaxVA {
+			return float64(toVA-fromVA) * tier.PerVA
+		}
+	}
+	return 0
+}
+
+// subsidizedRate mengembalikan tarif bersubsidi untuk daya tersebut, kalau ada di tabel tarif
+func subsidizedRate(capacity string, at time.Time) (float64, bool) {
+	rate, ok := lookupTariff(capacity, true, at)
+	if !ok || !rate.Subsidized {
+		return 0, false
+	}
+	return rate.PricePerKwh, true
+}
+
+// householdPeakVA memperkirakan beban puncak: yang terbesar dari puncak jadwal, dua perangkat
+// terbesar menyala bersamaan (untuk perangkat tanpa jadwal), dan beban live dari IoT.
+func householdPeakVA(userID int, records []applianceRecord) float64 {
+	var scheduled []scheduledLoad
+	var singles []float64
+	for _, rec := range records {
+		load := mcbLoadFor(rec)
+		singles = append(singles, load.VA)
+		scheduled = append(scheduled, scheduledLoad{
+			ID: rec.ID, Name: rec.Name, Watts: load.VA * float64(rec.Quantity), Schedule: rec.Schedule,
+		})
+	}
+	peak := estimatePeakLoad(scheduled).PeakWatts
+
+	sort.Sort(sort.Reverse(sort.Float64Slice(singles)))
+	pair := 0.0
+	for i := 0; i < len(singles) && i < 2; i++ {
+		pair += singles[i]
+	}
+	peak = max(peak, pair)
+
+	if live := currentLiveLoad(userID); live != nil {
+		peak = max(peak, live.VA)
+	}
+	return roundTo(peak, 0)
+}
+
+// recommendationMonthlyKwh: pakai data IoT 30 hari terakhir kalau ada, selain itu estimasi dari inventaris terakhir
+func recommendationMonthlyKwh(userID int, records []applianceRecord) (float64, string) {
+	now := time.Now()
+	if measured, ok := measuredKWhBetween(userID, now.AddDate(0, 0, -30), now); ok && measured > 0 {
+		return roundTo(measured, 2), "measured"
+	}
+	daily := 0.0
+	for _, rec := range records {
+		daily += rec.averageDailyKWh()
+	}
+	return roundTo(daily*30, 2), "estimated"
+}
+
+// buildCapacityRecommendation membandingkan daya sekarang dengan pilihan di sekitarnya lalu mengurutkannya.
+// Urutan: yang cukup untuk beban puncak dulu, lalu biaya tahun pertama (tagihan 12 bulan + biaya penyambungan).
+func buildCapacityRecommendation(records []applianceRecord, monthlyKwh float64, kwhSource string, peakVA float64, region RegionFees, prepaid, currentSubsidized bool, at time.Time) (CapacityRecommendation, error) {
+	current := records[0].BesarListrik
+	currentVA, ok := parseCapacityVA(current)
+	if !ok {
+		return CapacityRecommendation{}, fmt.Errorf("besar listrik %q tidak dikenali", current)
+	}
+
+	// Cari posisi daya sekarang di daftar pilihan (pakai yang terdekat kalau tidak persis sama)
+	currentIdx := 0
+	for i, capacity := range houseCapacities {
+		va, _ := parseCapacityVA(capacity)
+		if va <= currentVA {
+			currentIdx = i
+		}
+	}
+
+	rec := CapacityRecommendation{
+		CurrentCapacity: current,
+		MonthlyKwh:      monthlyKwh,
+		KwhSource:       kwhSource,
+		PeakVA:          peakVA,
+		RequiredVA:      roundTo(peakVA/mcbWarningRatio, 0),
+	}
+
+	billFor := func(capacity string, subsidized bool) BillBreakdown {
+		if subsidized {
+			if rate, ok := subsidizedRate(capacity, at); ok {
+				return calculateBillAtRate(monthlyKwh, capacity, rate, region, prepaid)
+			}
+		}
+		return calculateBill(monthlyKwh, capacity, BillingProfile{Region: region, Prepaid: prepaid}, at)
+	}
+
+	_, currentHasSubsidy := subsidizedRate(current, at)
+	currentSubsidized = currentSubsidized && currentHasSubsidy
+	currentBill := billFor(current, currentSubsidized)
+	// Selisih tagihan daya sekarang dengan dan tanpa subsidi = subsidi yang hilang kalau pindah daya
+	subsidyValue := 0.0
+	if currentSubsidized {
+		subsidyValue = roundTo(billFor(current, false).Total-currentBill.Total, 0)
+	}
+
+	from := max(currentIdx-capacityNeighbours, 0)
+	to := min(currentIdx+capacityNeighbours, len(houseCapacities)-1)
+	for i := from; i <= to; i++ {
+		capacity := houseCapacities[i]
+		va, _ := parseCapacityVA(capacity)
+		option := CapacityOption{Capacity: capacity, CapacityVA: va, Current: i == currentIdx, Reasons: []string{}}
+		if option.Current {
+			option.Capacity = current
+			option.CapacityVA = currentVA
+			va = currentVA
+		}
+
+		// Subsidi hanya melekat pada sambungan yang sudah ada; pindah daya berarti pindah ke tarif non-subsidi
+		option.Subsidized = option.Current && currentSubsidized
+		option.Bill = billFor(option.Capacity, option.Subsidized)
+		option.MonthlyDifference = roundTo(option.Bill.Total-currentBill.Total, 0)
+		if !option.Current && currentSubsidized {
+			option.SubsidyLoss = subsidyValue
+		}
+		option.ConnectionFee = connectionFee(currentVA, va)
+		option.FirstYearCost = roundTo(option.Bill.Total*12+option.ConnectionFee, 0)
+		option.PeakPercent = roundTo(peakVA/float64(va)*100, 1)
+		option.Fits = peakVA <= float64(va)*mcbWarningRatio
+
+		if option.Fits {
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Beban puncak %.0f VA hanya %.0f%% dari daya, MCB aman", peakVA, option.PeakPercent))
+		} else if peakVA > float64(va) {
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Beban puncak %.0f VA melebihi daya %d VA, MCB akan sering turun", peakVA, va))
+		} else {
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Beban puncak %.0f VA sudah %.0f%% dari daya, rawan MCB turun", peakVA, option.PeakPercent))
+		}
+		switch {
+		case option.MonthlyDifference > 0:
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Tagihan naik sekitar Rp %.0f per bulan", option.MonthlyDifference))
+		case option.MonthlyDifference < 0:
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Tagihan turun sekitar Rp %.0f per bulan", -option.MonthlyDifference))
+		}
+		if option.Bill.MinimumApplied {
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Pemakaian di bawah rekening minimum %.0f kWh", option.Bill.MinimumKwh))
+		}
+		if option.SubsidyLoss > 0 {
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Kehilangan subsidi senilai Rp %.0f per bulan", option.SubsidyLoss))
+		}
+		if option.ConnectionFee > 0 {
+			option.Reasons = append(option.Reasons, fmt.Sprintf("Biaya penyambungan sekali bayar Rp %.0f", option.ConnectionFee))
+			if option.MonthlyDifference < 0 {
+				months := roundTo(option.ConnectionFee/-option.MonthlyDifference, 1)
+				option.PaybackMonths = &months
+			}
+		}
+		rec.Options = append(rec.Options, option)
+	}
+
+	sort.SliceStable(rec.Options, func(i, j int) bool {
+		a, b := rec.Options[i], rec.Options[j]
+		if a.Fits != b.Fits {
+			return a.Fits
+		}
+		if !a.Fits {
+			// Belum ada yang cukup: daya yang lebih besar lebih mendekati aman
+			return a.CapacityVA > b.CapacityVA
+		}
+		if a.FirstYearCost != b.FirstYearCost {
+			return a.FirstYearCost < b.FirstYearCost
+		}
+		return a.Current
+	})
+	for i := range rec.Options {
+		rec.Options[i].Rank = i + 1
+	}
+
+	best := rec.Options[0]
+	rec.Recommended = best.Capacity
+	switch {
+	case best.Current:
+		rec.Summary = fmt.Sprintf("Daya %s sudah paling sesuai untuk pemakaian Anda", best.Capacity)
+	case best.CapacityVA > currentVA && best.Fits:
+		rec.Summary = fmt.Sprintf("Naikkan daya ke %s agar beban puncak %.0f VA tidak membuat MCB turun", best.Capacity, peakVA)
+	case best.CapacityVA > currentVA:
+		rec.Summary = fmt.Sprintf("Beban puncak %.0f VA terlalu besar; naikkan daya ke %s atau kurangi pemakaian bersamaan", peakVA, best.Capacity)
+	default:
+		rec.Summary = fmt.Sprintf("Turunkan daya ke %s untuk menghemat tagihan; beban puncak masih aman", best.Capacity)
+	}
+	return rec, nil
+}
+
+// CapacityRecommendationHandler memberi rekomendasi naik/turun daya beserta hitungan untung-ruginya.
+// Query opsional: subsidized=true/false menimpa status subsidi di profil user.
+func CapacityRecommendationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	records, err := latestSubmissionRecords(userID)
+	if err != nil {
+		log.Printf("❌ CapacityRecommendationHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, `{"error": "Belum ada data perangkat"}`, http.StatusNotFound)
+		return
+	}
+
+	// Default: status subsidi di profil; 450 VA rumah tangga selalu bersubsidi
+	profile := userBillingProfile(userID)
+	currentVA, _ := parseCapacityVA(records[0].BesarListrik)
+	subsidized := profile.Subsidized || currentVA <= 450
+	if raw := r.URL.Query().Get("subsidized"); raw != "" {
+		subsidized, err = strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, `{"error": "Parameter subsidized harus true/false"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	monthlyKwh, source := recommendationMonthlyKwh(userID, records)
+	peakVA := householdPeakVA(userID, records)
+
+	recommendation, err := buildCapacityRecommendation(records, monthlyKwh, source, peakVA, profile.Region, profile.Prepaid, subsidized, time.Now())
+	if err != nil {
+		http.Error(w, `{"error": "Besar listrik tidak dikenali"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recommendation)
+}
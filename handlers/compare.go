@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	uncategorizedLabel = "Uncategorised"
+	// compareChangeTolerance: perubahan di bawah 2% dianggap tetap
+	compareChangeTolerance = 2.0
+	maxCompareDrivers      = 3
+)
+
+type PeriodTotals struct {
+	Period      string  `json:"period"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Kwh         float64 `json:"kwh"`
+	Rp          float64 `json:"rp"`
+	MeasuredKwh float64 `json:"measured_kwh"`
+	Source      string  `json:"source"`
+	Confidence  string  `json:"confidence"`
+}
+
+type ComparisonRow struct {
+	Name         string   `json:"name"`
+	Brand        string   `json:"brand,omitempty"`
+	Category     string   `json:"category,omitempty"`
+	CurrentKwh   float64  `json:"current_kwh"`
+	PreviousKwh  float64  `json:"previous_kwh"`
+	DeltaKwh     float64  `json:"delta_kwh"`
+	DeltaPercent *float64 `json:"delta_percent"`
+	CurrentRp    float64  `json:"current_rp"`
+	PreviousRp   float64  `json:"previous_rp"`
+	DeltaRp      float64  `json:"delta_rp"`
+	Status       string   `json:"status"`
+}
+
+type ComparisonResponse struct {
+	Mode         string          `json:"mode"`
+	DaysCompared int             `json:"days_compared"`
+	Current      PeriodTotals    `json:"current"`
+	Previous     PeriodTotals    `json:"previous"`
+	DeltaKwh     float64         `json:"delta_kwh"`
+	DeltaPercent *float64        `json:"delta_percent"`
+	DeltaRp      float64         `json:"delta_rp"`
+	Categories   []ComparisonRow `json:"categories"`
+	Appliances   []ComparisonRow `json:"appliances"`
+	Drivers      []ComparisonRow `json:"drivers"`
+	Explanation  string          `json:"explanation"`
+}
+
+// periodUsage: pemakaian satu periode, total dan per perangkat (identityKey) / per kategori
+type periodUsage struct {
+	usageTotal
+	Appliances    map[string]usageTotal
+	Categories    map[string]usageTotal
+	ApplianceInfo map[string]applianceRecord
+}
+
+// collectPeriodUsage menjumlahkan pemakaian per jam pada [from, to) dengan data terukur digabung lewat
+// forEachUsageHour. Energi meter utama yang tidak bisa dibagi ke perangkat masuk total dan kategori Uncategorised.
+func collectPeriodUsage(records []applianceRecord, measured measuredUsage, profile BillingProfile, from, to time.Time) periodUsage {
+	usage := periodUsage{
+		Appliances:    make(map[string]usageTotal),
+		Categories:    make(map[string]usageTotal),
+		ApplianceInfo: make(map[string]applianceRecord),
+	}
+	forEachUsageHour(records, measured, profile, from, to, func(hour usageHour) {
+		usage.addHour(hour)
+		for key, kwh := range hour.Kwh {
+			category := uncategorizedLabel
+			if rec, exists := hour.Records[key]; exists {
+				if rec.CategoryName != "" {
+					category = rec.CategoryName
+				}
+				appliance := usage.Appliances[key]
+				appliance.add(kwh, hour.Rate, hour.Measured[key])
+				usage.Appliances[key] = appliance
+				usage.ApplianceInfo[key] = rec
+			}
+			total := usage.Categories[category]
+			total.add(kwh, hour.Rate, hour.Measured[key])
+			usage.Categories[category] = total
+		}
+	})
+	return usage
+}
+
+// periodTotals mengisi kWh, Rp, dan sumber data ringkasan periode
+func (usage periodUsage) periodTotals(totals PeriodTotals) PeriodTotals {
+	totals.Kwh, totals.Rp = roundTo(usage.Kwh, 2), roundTo(usage.Rp, 0)
+	totals.MeasuredKwh = roundTo(usage.MeasuredKwh, 2)
+	totals.Source, totals.Confidence = usage.label()
+	return totals
+}
+
+// percentChange mengembalikan nil kalau pembanding nol (persentase tidak bermakna)
+func percentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	pct := roundTo((current-previous)/previous*100, 1)
+	return &pct
+}
+
+func comparisonStatus(current, previous float64) string {
+	switch {
+	case previous == 0 && current > 0:
+		return "new"
+	case current == 0 && previous > 0:
+		return "removed"
+	case previous > 0 && math.Abs((current-previous)/previous*100) < compareChangeTolerance:
+		return "unchanged"
+	case current > previous:
+		return "increased"
+	default:
+		return "decreased"
+	}
+}
+
+func newComparisonRow(name string, currentKwh, previousKwh, currentRp, previousRp float64) ComparisonRow {
+	return ComparisonRow{
+		Name:         name,
+		CurrentKwh:   roundTo(currentKwh, 2),
+		PreviousKwh:  roundTo(previousKwh, 2),
+		DeltaKwh:     roundTo(currentKwh-previousKwh, 2),
+		DeltaPercent: percentChange(currentKwh, previousKwh),
+		CurrentRp:    roundTo(currentRp, 0),
+		PreviousRp:   roundTo(previousRp, 0),
+		DeltaRp:      roundTo(currentRp-previousRp, 0),
+		Status:       comparisonStatus(currentKwh, previousKwh),
+	}
+}
+
+// sortByImpact: perubahan absolut terbesar di atas
+func sortByImpact(rows []ComparisonRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return math.Abs(rows[i].DeltaKwh) > math.Abs(rows[j].DeltaKwh)
+	})
+}
+
+// buildComparison membandingkan dua periode dengan jumlah hari yang sama (like-for-like)
+func buildComparison(mode string, cur, prev periodUsage, current, previous PeriodTotals, curFrom, curTo time.Time) ComparisonResponse {
+	response := ComparisonResponse{
+		Mode:         mode,
+		DaysCompared: int(math.Round(curTo.Sub(curFrom).Hours() / 24)),
+		Current:      cur.periodTotals(current),
+		Previous:     prev.periodTotals(previous),
+		DeltaKwh:     roundTo(cur.Kwh-prev.Kwh, 2),
+		DeltaPercent: percentChange(cur.Kwh, prev.Kwh),
+		DeltaRp:      roundTo(cur.Rp-prev.Rp, 0),
+		Categories:   []ComparisonRow{},
+		Appliances:   []ComparisonRow{},
+		Drivers:      []ComparisonRow{},
+	}
+
+	categories := make(map[string]bool)
+	for name := range cur.Categories {
+		categories[name] = true
+	}
+	for name := range prev.Categories {
+		categories[name] = true
+	}
+	for name := range categories {
+		response.Categories = append(response.Categories,
+			newComparisonRow(name, cur.Categories[name].Kwh, prev.Categories[name].Kwh, cur.Categories[name].Rp, prev.Categories[name].Rp))
+	}
+	sort.Slice(response.Categories, func(i, j int) bool { return response.Categories[i].Name < response.Categories[j].Name })
+	sortByImpact(response.Categories)
+
+	appliances := make(map[string]applianceRecord)
+	for key, rec := range prev.ApplianceInfo {
+		appliances[key] = rec
+	}
+	for key, rec := range cur.ApplianceInfo {
+		appliances[key] = rec
+	}
+	for key, rec := range appliances {
+		row := newComparisonRow(rec.Name, cur.Appliances[key].Kwh, prev.Appliances[key].Kwh, cur.Appliances[key].Rp, prev.Appliances[key].Rp)
+		row.Brand = rec.Brand
+		row.Category = rec.CategoryName
+		if row.Category == "" {
+			row.Category = uncategorizedLabel
+		}
+		response.Appliances = append(response.Appliances, row)
+	}
+	sort.Slice(response.Appliances, func(i, j int) bool { return response.Appliances[i].Name < response.Appliances[j].Name })
+	sortByImpact(response.Appliances)
+
+	// Pendorong perubahan: perangkat yang berubah searah dengan total
+	for _, row := range response.Appliances {
+		if len(response.Drivers) == maxCompareDrivers {
+			break
+		}
+		if row.DeltaKwh == 0 || (row.DeltaKwh > 0) != (response.DeltaKwh > 0) {
+			continue
+		}
+		response.Drivers = append(response.Drivers, row)
+	}
+
+	response.Explanation = explainComparison(response)
+	return response
+}
+
+// explainComparison menyusun kalimat ringkas untuk layar insight
+func explainComparison(c ComparisonResponse) string {
+	label := "bulan lalu"
+	if c.Mode == "yoy" {
+		label = "bulan yang sama tahun lalu"
+	}
+	if c.Previous.Kwh == 0 {
+		if c.Current.Kwh == 0 {
+			return "Belum ada data pemakaian untuk dibandingkan."
+		}
+		return fmt.Sprintf("Belum ada data %s untuk dibandingkan. Pemakaian periode ini %.1f kWh (Rp %.0f).", label, c.Current.Kwh, c.Current.Rp)
+	}
+
+	pct := 0.0
+	if c.DeltaPercent != nil {
+		pct = *c.DeltaPercent
+	}
+	if math.Abs(pct) < compareChangeTolerance {
+		return fmt.Sprintf("Pemakaian relatif sama dengan %s (%.1f kWh vs %.1f kWh).", label, c.Current.Kwh, c.Previous.Kwh)
+	}
+
+	direction, verdict := "lebih boros", "naik"
+	if c.DeltaKwh < 0 {
+		direction, verdict = "lebih hemat", "turun"
+	}
+	text := fmt.Sprintf("Pemakaian %.1f%% %s dibanding %s (%s %.1f kWh, sekitar Rp %.0f).",
+		math.Abs(pct), direction, label, verdict, math.Abs(c.DeltaKwh), math.Abs(c.DeltaRp))
+
+	if len(c.Drivers) > 0 {
+		var parts []string
+		for _, driver := range c.Drivers {
+			switch driver.Status {
+			case "new":
+				parts = append(parts, fmt.Sprintf("%s (baru, +%.1f kWh)", driver.Name, driver.DeltaKwh))
+			case "removed":
+				parts = append(parts, fmt.Sprintf("%s (tidak dipakai lagi, %.1f kWh)", driver.Name, driver.DeltaKwh))
+			default:
+				parts = append(parts, fmt.Sprintf("%s (%+.1f kWh)", driver.Name, driver.DeltaKwh))
+			}
+		}
+		text += " Penyebab utama: " + strings.Join(parts, ", ") + "."
+	}
+	return text
+}
+
+// comparePeriods menangani mode "mom" dan "yoy". Query opsional month=YYYY-MM (default bulan ini).
+// Kalau bulan yang dipilih masih berjalan, kedua periode dipotong ke jumlah hari yang sama.
+func comparePeriods(w http.ResponseWriter, r *http.Request, mode string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	curFrom := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if raw := r.URL.Query().Get("month"); raw != "" {
+		parsed, err := time.ParseInLocation("2006-01", raw, now.Location())
+		if err != nil {
+			http.Error(w, `{"error": "Format month tidak valid, gunakan YYYY-MM"}`, http.StatusBadRequest)
+			return
+		}
+		if parsed.After(curFrom) {
+			http.Error(w, `{"error": "Bulan tidak boleh di masa depan"}`, http.StatusBadRequest)
+			return
+		}
+		curFrom = parsed
+	}
+
+	prevFrom := curFrom.AddDate(0, -1, 0)
+	if mode == "yoy" {
+		prevFrom = curFrom.AddDate(-1, 0, 0)
+	}
+
+	// Jumlah hari yang dibandingkan: sebulan penuh, atau sampai hari ini untuk bulan berjalan,
+	// dan tidak lebih dari panjang bulan pembanding
+	days := curFrom.AddDate(0, 1, -1).Day()
+	if !today.Before(curFrom) && today.Before(curFrom.AddDate(0, 1, 0)) {
+		days = today.Day()
+	}
+	days = min(days, prevFrom.AddDate(0, 1, -1).Day())
+	curTo := curFrom.AddDate(0, 0, days)
+	prevTo := prevFrom.AddDate(0, 0, days)
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ comparePeriods: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	profile := userBillingProfile(userID)
+
+	periodBounds := func(from, to time.Time) PeriodTotals {
+		return PeriodTotals{Period: from.Format("2006-01"), From: from.Format("2006-01-02"), To: to.AddDate(0, 0, -1).Format("2006-01-02")}
+	}
+	cur := collectPeriodUsage(records, loadMeasuredUsage(userID, curFrom, curTo, now.Location()), profile, curFrom, curTo)
+	prev := collectPeriodUsage(records, loadMeasuredUsage(userID, prevFrom, prevTo, now.Location()), profile, prevFrom, prevTo)
+	response := buildComparison(mode, cur, prev, periodBounds(curFrom, curTo), periodBounds(prevFrom, prevTo), curFrom, curTo)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CompareMonthOverMonthHandler: bulan ini vs bulan lalu
+func CompareMonthOverMonthHandler(w http.ResponseWriter, r *http.Request) {
+	comparePeriods(w, r, "mom")
+}
+
+// CompareYearOverYearHandler: bulan ini vs bulan yang sama tahun lalu
+func CompareYearOverYearHandler(w http.ResponseWriter, r *http.Request) {
+	comparePeriods(w, r, "yoy")
+}
@@ -6,6 +6,18 @@ import (
 	"net/http"
 )
 
+// houseCapacities: pilihan daya tersambung rumah tangga, urut dari yang terkecil
+var houseCapacities = []string{
+	"450 VA",
+	"900 VA",
+	"1.300 VA",
+	"2.200 VA",
+	"3.500 VA",
+	"4.400 VA",
+	"5.500 VA",
+	"6.600 VA and above",
+}
+
 func GetHouseCapacityHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -21,17 +33,6 @@ func GetHouseCapacityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	houseCapacities := []string{
-		"450 VA",
-		"900 VA",
-		"1.300 VA",
-		"2.200 VA",
-		"3.500 VA",
-		"4.400 VA",
-		"5.500 VA",
-		"6.600 VA and above",
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(houseCapacities); err != nil {
 		log.Printf("❌ Error encoding response: %v", err)
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// IdempotencyWindow: berapa lama respons disimpan untuk dikembalikan lagi saat retry
+	IdempotencyWindow = 24 * time.Hour
+	// idempotencyLockTimeout: request yang "sedang diproses" lebih lama dari ini dianggap gagal (server crash)
+	idempotencyLockTimeout  = 2 * time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyRecorder meneruskan respons ke client sekaligus menyimpannya untuk replay
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// reserveIdempotencyKey mencoba mengklaim key; false kalau key sudah dipakai request sebelumnya
+func reserveIdempotencyKey(userID int, endpoint, key, requestHash string) (bool, error) {
+	_, err := db.DB.Exec(`
+		INSERT INTO idempotency_keys (user_id, endpoint, idem_key, request_hash)
+		VALUES (?, ?, ?, ?)`, userID, endpoint, key, requestHash)
+	if err == nil {
+		return true, nil
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return false, nil
+	}
+	return false, err
+}
+
+// WithIdempotency membungkus handler POST supaya retry dengan header Idempotency-Key yang sama
+// tidak menyimpan data dua kali. Respons pertama disimpan dan dikirim ulang selama IdempotencyWindow.
+// Tanpa header, handler berjalan seperti biasa.
+func WithIdempotency(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, `{"error": "Idempotency-Key terlalu panjang"}`, http.StatusBadRequest)
+			return
+		}
+
+		session, err := Store.Get(r, "elektronik_rumah_session")
+		if err != nil {
+			next(w, r)
+			return
+		}
+		userID, ok := session.Values["user_id"].(int)
+		if !ok {
+			// Biarkan handler yang menolak request tanpa login
+			next(w, r)
+			return
+		}
+
+		// Body dibaca dulu untuk hash, lalu dipasang lagi supaya handler tetap bisa membacanya
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, `{"error": "Gagal membaca request"}`, http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(sum[:])
+
+		// Key yang sudah lewat masa berlaku atau macet di tengah proses boleh dipakai lagi
+		_, err = db.DB.Exec(`
+			DELETE FROM idempotency_keys
+			WHERE user_id = ? AND endpoint = ? AND idem_key = ?
+			  AND (created_at < NOW() - INTERVAL ? SECOND
+			       OR (status_code IS NULL AND created_at < NOW() - INTERVAL ? SECOND))`,
+			userID, endpoint, key, int(IdempotencyWindow.Seconds()), int(idempotencyLockTimeout.Seconds()))
+		if err != nil {
+			log.Printf("❌ WithIdempotency: Gagal membersihkan key lama: %v", err)
+		}
+
+		reserved, err := reserveIdempotencyKey(userID, endpoint, key, requestHash)
+		if err != nil {
+			log.Printf("❌ WithIdempotency: Gagal menyimpan key: %v", err)
+			http.Error(w, `{"error": "Gagal memproses Idempotency-Key"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			var storedHash string
+			var statusCode sql.NullInt64
+			var responseBody sql.NullString
+			err := db.DB.QueryRow(`
+				SELECT request_hash, status_code, response_body FROM idempotency_keys
+				WHERE user_id = ? AND endpoint = ? AND idem_key = ?`, userID, endpoint, key).
+				Scan(&storedHash, &statusCode, &responseBody)
+			if err != nil {
+				log.Printf("❌ WithIdempotency: Gagal membaca key: %v", err)
+				http.Error(w, `{"error": "Gagal memproses Idempotency-Key"}`, http.StatusInternalServerError)
+				return
+			}
+			if storedHash != requestHash {
+				http.Error(w, `{"error": "Idempotency-Key sudah dipakai untuk data yang berbeda"}`, http.StatusUnprocessableEntity)
+				return
+			}
+			if !statusCode.Valid {
+				http.Error(w, `{"error": "Request dengan Idempotency-Key ini masih diproses"}`, http.StatusConflict)
+				return
+			}
+
+			log.Printf("🔁 Replay respons %s untuk user %d (key %s)", endpoint, userID, key)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(int(statusCode.Int64))
+			w.Write([]byte(responseBody.String))
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		// Error server tidak disimpan supaya client bisa retry dengan key yang sama
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			if _, err := db.DB.Exec(`
+				DELETE FROM idempotency_keys WHERE user_id = ? AND endpoint = ? AND idem_key = ?`,
+				userID, endpoint, key); err != nil {
+				log.Printf("❌ WithIdempotency: Gagal melepas key: %v", err)
+			}
+			return
+		}
+
+		if _, err := db.DB.Exec(`
+			UPDATE idempotency_keys SET status_code = ?, response_body = ?
+			WHERE user_id = ? AND endpoint = ? AND idem_key = ?`,
+			recorder.status, recorder.body.String(), userID, endpoint, key); err != nil {
+			log.Printf("❌ WithIdempotency: Gagal menyimpan respons: %v", err)
+		}
+	}
+}
+
+// purgeIdempotencyKeys menghapus key yang sudah lewat masa berlaku
+func purgeIdempotencyKeys() {
+	result, err := db.DB.Exec(`
+		DELETE FROM idempotency_keys WHERE created_at < NOW() - INTERVAL ? SECOND`,
+		int(IdempotencyWindow.Seconds()))
+	if err != nil {
+		log.Printf("❌ [PURGE] Gagal menghapus idempotency key lama: %v", err)
+		return
+	}
+	if purged, _ := result.RowsAffected(); purged > 0 {
+		log.Printf("🧹 [PURGE] %d idempotency key kedaluwarsa dihapus", purged)
+	}
+}
+
+// StartIdempotencyPurgeScheduler menghapus key kedaluwarsa sekali saat start lalu setiap interval
+func StartIdempotencyPurgeScheduler(interval time.Duration) {
+	go func() {
+		log.Printf("⏰ Purge idempotency key dimulai, masa berlaku %v, cek setiap %v...", IdempotencyWindow, interval)
+		purgeIdempotencyKeys()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeIdempotencyKeys()
+		}
+	}()
+}
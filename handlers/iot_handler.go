@@ -76,6 +76,9 @@ func syncAndNotify(app *firebase.App, firestoreClient *firestore.Client, data Sy
 		}
 	}
 
+	// Cek beban total terhadap daya tersambung (risiko MCB turun)
+	checkLiveLoad(app, data)
+
 	shouldNotify := false
 	var notifTitle string
 	var notifBody string
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// IotLinkRequest: tautkan sensor IoT ke perangkat di riwayat, atau tandai sebagai meter utama (mengukur seluruh rumah).
+// appliance_id null / 0 dan main_meter false berarti tautan dilepas.
+type IotLinkRequest struct {
+	DeviceLabel string `json:"device_label"`
+	ApplianceID *int   `json:"appliance_id"`
+	MainMeter   bool   `json:"main_meter"`
+}
+
+// sensorRoles: peran tiap label sensor. Sensor yang tidak ditautkan tapi ditempatkan di ruangan
+// dihitung sebagai pemakaian ruangan itu (Placed). Sensor yang bukan meter utama, tidak ditautkan,
+// dan tidak punya ruangan (mis. colokan pintar yang belum diatur) tidak dipakai sama sekali.
+type sensorRoles struct {
+	Main   map[string]bool            // label meter utama
+	Linked map[string]string          // label -> identityKey perangkat
+	Placed map[string]applianceRecord // label -> perangkat semu (nama = label) di ruangan sensor
+}
+
+// loadSensorRoles memuat meter utama dan tautan sensor-perangkat milik user
+func loadSensorRoles(userID int) (sensorRoles, error) {
+	roles := sensorRoles{Main: make(map[string]bool), Linked: make(map[string]string), Placed: make(map[string]applianceRecord)}
+
+	links, err := loadIotLinks(userID)
+	if err != nil {
+		return roles, err
+	}
+	for key, label := range links {
+		roles.Linked[label] = key
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT pi.device_label, pi.meter_utama, r.id, COALESCE(r.nama_ruangan, '')
+		FROM perangkat_iot pi
+		LEFT JOIN ruangan r ON r.id = pi.ruangan_id AND r.user_id = pi.user_id
+		WHERE pi.user_id = ? AND pi.riwayat_id IS NULL AND (pi.meter_utama = TRUE OR r.id IS NOT NULL)`, userID)
+	if err != nil {
+		return roles, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label string
+		var main bool
+		var roomID sql.NullInt64
+		var roomName string
+		if err := rows.Scan(&label, &main, &roomID, &roomName); err != nil {
+			return roles, err
+		}
+		if main {
+			roles.Main[label] = true
+			continue
+		}
+		id := int(roomID.Int64)
+		roles.Placed[label] = applianceRecord{Name: label, RoomID: &id, RoomName: roomName, Quantity: 1}
+	}
+	return roles, rows.Err()
+}
+
+// loadIotLinks mengembalikan label sensor per perangkat (identityKey).
+// Tautan disimpan ke id baris riwayat, tapi dicocokkan lewat nama + merek supaya tetap berlaku setelah submit ulang.
+func loadIotLinks(userID int) (map[string]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT pi.device_label, rp.nama_perangkat, COALESCE(rp.merek, '')
+		FROM perangkat_iot pi
+		JOIN riwayat_perangkat rp ON rp.id = pi.riwayat_id AND rp.user_id = pi.user_id
+		WHERE pi.user_id = ?
+		ORDER BY pi.device_label`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make(map[string]string)
+	for rows.Next() {
+		var label string
+		var rec applianceRecord
+		if err := rows.Scan(&label, &rec.Name, &rec.Brand); err != nil {
+			return nil, err
+		}
+		if _, exists := links[rec.identityKey()]; !exists {
+			links[rec.identityKey()] = label
+		}
+	}
+	return links, rows.Err()
+}
+
+// LinkIotDeviceHandler menautkan sensor IoT ke perangkat atau menandainya sebagai meter utama (POST /iot/link)
+func LinkIotDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req IotLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+		return
+	}
+	req.DeviceLabel = strings.TrimSpace(req.DeviceLabel)
+	if req.DeviceLabel == "" {
+		http.Error(w, `{"error": "device_label wajib diisi"}`, http.StatusBadRequest)
+		return
+	}
+
+	var applianceID interface{}
+	if req.ApplianceID != nil && *req.ApplianceID > 0 {
+		if req.MainMeter {
+			http.Error(w, `{"error": "Meter utama tidak bisa ditautkan ke satu perangkat"}`, http.StatusBadRequest)
+			return
+		}
+		var exists int
+		if err := db.DB.QueryRow("SELECT COUNT(*) FROM riwayat_perangkat WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+			*req.ApplianceID, userID).Scan(&exists); err != nil || exists == 0 {
+			http.Error(w, `{"error": "Perangkat tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+		applianceID = *req.ApplianceID
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO perangkat_iot (user_id, device_label, riwayat_id, meter_utama) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE riwayat_id = VALUES(riwayat_id), meter_utama = VALUES(meter_utama)`,
+		userID, req.DeviceLabel, applianceID, req.MainMeter)
+	if err != nil {
+		log.Printf("❌ LinkIotDeviceHandler: Gagal menautkan sensor: %v", err)
+		http.Error(w, `{"error": "Gagal menautkan sensor ke perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Sensor berhasil ditautkan",
+		"device_label": req.DeviceLabel,
+		"appliance_id": applianceID,
+		"main_meter":   req.MainMeter,
+	})
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// Umur pakai default kalau user tidak mengisi
+	defaultLifespanYears = 10
+	// Perangkat dianggap boros kalau dayanya minimal 25% di atas padanan katalog
+	replacementMinRatio = 1.25
+	// Padanan katalog minimal 30% dari daya perangkat lama, biar tidak membandingkan kelas yang beda jauh
+	catalogMinShare = 0.3
+)
+
+// ApplianceLifecycleRequest: tanggal berformat YYYY-MM-DD, null untuk mengosongkan
+type ApplianceLifecycleRequest struct {
+	ID                    int     `json:"id"`
+	PurchaseDate          *string `json:"purchase_date"`
+	WarrantyUntil         *string `json:"warranty_until"`
+	ExpectedLifespanYears *int    `json:"expected_lifespan_years"`
+}
+
+type ApplianceLifecycle struct {
+	ID                    int      `json:"id"`
+	PurchaseDate          *string  `json:"purchase_date"`
+	WarrantyUntil         *string  `json:"warranty_until"`
+	ExpectedLifespanYears int      `json:"expected_lifespan_years"`
+	AgeYears              *float64 `json:"age_years"`
+	RemainingYears        *float64 `json:"remaining_years"`
+	UnderWarranty         bool     `json:"under_warranty"`
+}
+
+type ReplacementRecommendation struct {
+	ApplianceID         int      `json:"appliance_id"`
+	Name                string   `json:"name"`
+	Brand               string   `json:"brand"`
+	CurrentPower        float64  `json:"current_power"`
+	Quantity            int      `json:"quantity"`
+	AgeYears            *float64 `json:"age_years"`
+	CatalogProduct      string   `json:"catalog_product"`
+	CatalogPower        float64  `json:"catalog_power"`
+	MonthlyKwhSaved     float64  `json:"monthly_kwh_saved"`
+	MonthlySavingsRp    float64  `json:"monthly_savings_rp"`
+	ReplacementCostRp   *float64 `json:"replacement_cost_rp"`
+	PaybackMonths       *float64 `json:"payback_months"`
+	PriorityScore       float64  `json:"priority_score"`
+	Reason              string   `json:"reason"`
+	MonthlySavingsLabel string   `json:"monthly_savings_label"`
+}
+
+// parseOptionalDate: nil/"" -> NULL, selain itu wajib YYYY-MM-DD
+func parseOptionalDate(value *string) (interface{}, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse("2006-01-02", *value)
+	if err != nil {
+		return nil, fmt.Errorf("format tanggal tidak valid, gunakan YYYY-MM-DD: %q", *value)
+	}
+	return parsed.Format("2006-01-02"), nil
+}
+
+func formatNullDate(value sql.NullTime) *string {
+	if !value.Valid {
+		return nil
+	}
+	formatted := value.Time.Format("2006-01-02")
+	return &formatted
+}
+
+// applianceAgeYears menghitung umur dalam tahun (1 desimal) dari tanggal beli
+func applianceAgeYears(purchase sql.NullTime, now time.Time) *float64 {
+	if !purchase.Valid || purchase.Time.After(now) {
+		return nil
+	}
+	age := math.Round(now.Sub(purchase.Time).Hours()/24/365.25*10) / 10
+	return &age
+}
+
+func buildLifecycle(id int, purchase, warranty sql.NullTime, lifespan sql.NullInt64, now time.Time) ApplianceLifecycle {
+	lifecycle := ApplianceLifecycle{
+		ID:                    id,
+		PurchaseDate:          formatNullDate(purchase),
+		WarrantyUntil:         formatNullDate(warranty),
+		ExpectedLifespanYears: defaultLifespanYears,
+		AgeYears:              applianceAgeYears(purchase, now),
+		UnderWarranty:         warranty.Valid && !warranty.Time.Before(now.Truncate(24*time.Hour)),
+	}
+	if lifespan.Valid && lifespan.Int64 > 0 {
+		lifecycle.ExpectedLifespanYears = int(lifespan.Int64)
+	}
+	if lifecycle.AgeYears != nil {
+		remaining := math.Round((float64(lifecycle.ExpectedLifespanYears)-*lifecycle.AgeYears)*10) / 10
+		lifecycle.RemainingYears = &remaining
+	}
+	return lifecycle
+}
+
+// ApplianceLifecycleHandler: GET ?id= untuk lihat umur/garansi, PUT untuk menyimpan
+func ApplianceLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var applianceID int
+	switch r.Method {
+	case http.MethodGet:
+		applianceID, err = strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil || applianceID <= 0 {
+			http.Error(w, `{"error": "Parameter id tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPut:
+		var req ApplianceLifecycleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		purchaseDate, err := parseOptionalDate(req.PurchaseDate)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		warrantyUntil, err := parseOptionalDate(req.WarrantyUntil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		var lifespan interface{}
+		if req.ExpectedLifespanYears != nil {
+			if *req.ExpectedLifespanYears <= 0 || *req.ExpectedLifespanYears > 50 {
+				http.Error(w, `{"error": "Umur pakai harus antara 1 dan 50 tahun"}`, http.StatusBadRequest)
+				return
+			}
+			lifespan = *req.ExpectedLifespanYears
+		}
+
+		result, err := db.DB.Exec(`
+			UPDATE riwayat_perangkat SET tanggal_beli = ?, garansi_sampai = ?, umur_pakai_tahun = ?
+			WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, purchaseDate, warrantyUntil, lifespan, req.ID, userID)
+		if err != nil {
+			log.Printf("❌ ApplianceLifecycleHandler: Gagal menyimpan siklus hidup: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan data perangkat"}`, http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			var exists int
+			db.DB.QueryRow("SELECT COUNT(*) FROM riwayat_perangkat WHERE id = ? AND user_id = ? AND deleted_at IS NULL", req.ID, userID).Scan(&exists)
+			if exists == 0 {
+				http.Error(w, `{"error": "Perangkat tidak ditemukan"}`, http.StatusNotFound)
+				return
+			}
+		}
+		applianceID = req.ID
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var purchase, warranty sql.NullTime
+	var lifespan sql.NullInt64
+	err = db.DB.QueryRow(`
+		SELECT tanggal_beli, garansi_sampai, umur_pakai_tahun
+		FROM riwayat_perangkat WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, applianceID, userID).Scan(&purchase, &warranty, &lifespan)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Perangkat tidak ditemukan"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("❌ ApplianceLifecycleHandler: Error querying appliance: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildLifecycle(applianceID, purchase, warranty, lifespan, time.Now()))
+}
+
+// ReplacementRecommendationsHandler membuat daftar "ganti duluan": perangkat submit terakhir yang
+// jauh lebih boros dari padanan katalog (produk) di kategori yang sama, diurutkan dari prioritas tertinggi
+func ReplacementRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT rp.id, rp.nama_perangkat, rp.merek, rp.kategori_id, rp.daya, rp.durasi,
+			   COALESCE(rp.jumlah, 1), COALESCE(rp.besar_listrik, ''),
+			   rp.tanggal_beli, rp.garansi_sampai, rp.umur_pakai_tahun
+		FROM riwayat_perangkat rp
+		WHERE rp.user_id = ? AND rp.kategori_id IS NOT NULL AND rp.deleted_at IS NULL AND rp.id_submit = (
+			SELECT id_submit FROM riwayat_perangkat
+			WHERE user_id = ? AND deleted_at IS NULL ORDER BY tanggal_input DESC, id DESC LIMIT 1
+		)`, userID, userID)
+	if err != nil {
+		log.Printf("❌ ReplacementRecommendationsHandler: Error querying appliances: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	schedules, err := loadUserSchedules(userID)
+	if err != nil {
+		log.Printf("⚠️ ReplacementRecommendationsHandler: Gagal memuat jadwal: %v", err)
+	}
+
+	now := time.Now()
+	subsidized := userSubsidized(userID)
+	recommendations := []ReplacementRecommendation{}
+	for rows.Next() {
+		var id, categoryID, quantity int
+		var name, brand, besarListrik string
+		var power, duration float64
+		var purchase, warranty sql.NullTime
+		var lifespan sql.NullInt64
+		if err := rows.Scan(&id, &name, &brand, &categoryID, &power, &duration, &quantity, &besarListrik,
+			&purchase, &warranty, &lifespan); err != nil {
+			log.Printf("❌ ReplacementRecommendationsHandler: Error scanning row: %v", err)
+			continue
+		}
+
+		// Padanan katalog: produk paling hemat di kategori yang sama
+		var productName string
+		var productPower float64
+		var productPrice sql.NullFloat64
+		err := db.DB.QueryRow(`
+			SELECT nama_produk, daya_watt, harga FROM produk
+			WHERE kategori_id = ? AND daya_watt >= ? AND daya_watt < ?
+			ORDER BY daya_watt ASC, harga IS NULL, harga ASC
+			LIMIT 1`, categoryID, power*catalogMinShare, power).Scan(&productName, &productPower, &productPrice)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("❌ ReplacementRecommendationsHandler: Error querying catalog: %v", err)
+			}
+			continue
+		}
+		if productPower <= 0 || power < productPower*replacementMinRatio {
+			continue
+		}
+
+		quantity = normalizeQuantity(quantity)
+		usageHours := averageDailyHours(schedules[id], duration)
+		monthlyKwhSaved := (power - productPower) * usageHours * float64(quantity) * 30 / 1000.0
+		monthlySavings := monthlyKwhSaved * tariffPerKwh(besarListrik, subsidized)
+		if monthlySavings <= 0 {
+			continue
+		}
+
+		lifecycle := buildLifecycle(id, purchase, warranty, lifespan, now)
+		// Perangkat yang makin mendekati/melewati umur pakai diprioritaskan (maksimal 2x)
+		ageFactor := 1.0
+		reason := fmt.Sprintf("%.0f W vs %.0f W pada %s", power, productPower, productName)
+		if lifecycle.AgeYears != nil {
+			ageFactor += math.Min(*lifecycle.AgeYears/float64(lifecycle.ExpectedLifespanYears), 1.0)
+			if *lifecycle.AgeYears >= float64(lifecycle.ExpectedLifespanYears) {
+				reason += fmt.Sprintf("; sudah %.1f tahun, melewati umur pakai %d tahun", *lifecycle.AgeYears, lifecycle.ExpectedLifespanYears)
+			}
+		}
+		if lifecycle.UnderWarranty {
+			reason += "; masih bergaransi"
+		}
+
+		recommendation := ReplacementRecommendation{
+			ApplianceID:         id,
+			Name:                name,
+			Brand:               brand,
+			CurrentPower:        power,
+			Quantity:            quantity,
+			AgeYears:            lifecycle.AgeYears,
+			CatalogProduct:      productName,
+			CatalogPower:        productPower,
+			MonthlyKwhSaved:     math.Round(monthlyKwhSaved*100) / 100,
+			MonthlySavingsRp:    math.Round(monthlySavings),
+			PriorityScore:       math.Round(monthlySavings * ageFactor),
+			Reason:              reason,
+			MonthlySavingsLabel: formatRupiah(monthlySavings),
+		}
+		if productPrice.Valid && productPrice.Float64 > 0 {
+			cost := productPrice.Float64 * float64(quantity)
+			payback := math.Round(cost/monthlySavings*10) / 10
+			recommendation.ReplacementCostRp = &cost
+			recommendation.PaybackMonths = &payback
+		}
+		recommendations = append(recommendations, recommendation)
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return recommendations[i].PriorityScore > recommendations[j].PriorityScore
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recommendations)
+}
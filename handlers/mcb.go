@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	firebase "firebase.google.com/go"
+)
+
+const (
+	// mcbWarningRatio: beban di atas 80% daya tersambung sudah rawan MCB turun
+	mcbWarningRatio = 0.8
+	// defaultPowerFactor dipakai untuk perangkat yang jenisnya tidak dikenali (dan data IoT tanpa ampere)
+	defaultPowerFactor = 0.85
+	// maxComboSize: kombinasi perangkat yang dicek paling banyak 4 sekaligus
+	maxComboSize   = 4
+	maxRiskyCombos = 20
+	// maxComboCandidates: batas kandidat kombinasi yang dicek jadwalnya
+	maxComboCandidates = 200
+	// liveLoadTTL: data watt IoT lebih tua dari ini dianggap sensor sudah tidak mengirim
+	liveLoadTTL     = time.Minute
+	liveCapacityTTL = 10 * time.Minute
+	liveAlertBucket = 30 * time.Minute
+)
+
+// powerFactorRules: asumsi faktor daya berdasarkan kata kunci nama/kategori perangkat.
+// Beban resistif (pemanas) ~1, motor/kompresor ~0.8, elektronik dengan adaptor ~0.9.
+var powerFactorRules = []struct {
+	Keywords []string
+	Factor   float64
+	Kind     string
+}{
+	{[]string{"setrika", "iron", "rice cooker", "magic com", "penanak", "water heater", "pemanas", "kettle", "ketel", "oven", "kompor", "dispenser", "hair dryer", "pengering"}, 1.0, "resistif"},
+	{[]string{"pompa", "pump", "kulkas", "refrigerator", "lemari es", "freezer", "ac", "air conditioner", "mesin cuci", "washing", "kipas", "fan", "blender", "vacuum", "bor"}, 0.8, "motor"},
+	{[]string{"tv", "televisi", "komputer", "computer", "laptop", "charger", "lampu", "lamp", "led", "router", "monitor", "printer", "microwave"}, 0.9, "elektronik"},
+}
+
+type McbLoad struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Watts       float64 `json:"watts"`
+	PowerFactor float64 `json:"power_factor"`
+	VA          float64 `json:"va"`
+	Kind        string  `json:"kind"`
+}
+
+type RiskyCombination struct {
+	Devices     []string `json:"devices"`
+	TotalVA     float64  `json:"total_va"`
+	CapacityPct float64  `json:"capacity_percent"`
+	WillTrip    bool     `json:"will_trip"`
+	Scheduled   bool     `json:"scheduled_together"`
+	ScheduledAt string   `json:"scheduled_at,omitempty"`
+}
+
+type LiveLoadStatus struct {
+	VA          float64 `json:"va"`
+	Watts       float64 `json:"watts"`
+	CapacityPct float64 `json:"capacity_percent"`
+	Sensors     int     `json:"sensors"`
+	UpdatedAt   string  `json:"updated_at"`
+}
+
+type McbRiskResponse struct {
+	BesarListrik      string             `json:"besar_listrik"`
+	CapacityVA        int                `json:"capacity_va"`
+	WarningVA         float64            `json:"warning_va"`
+	ScheduledPeakVA   float64            `json:"scheduled_peak_va"`
+	ScheduledPeakAt   string             `json:"scheduled_peak_at"`
+	ScheduledPeakPct  float64            `json:"scheduled_peak_percent"`
+	Loads             []McbLoad          `json:"loads"`
+	RiskyCombinations []RiskyCombination `json:"risky_combinations"`
+	Live              *LiveLoadStatus    `json:"live"`
+}
+
+// powerFactorFor menebak faktor daya dari nama dan kategori perangkat
+func powerFactorFor(name, category string) (float64, string) {
+	text := " " + strings.ToLower(name+" "+category) + " "
+	for _, rule := range powerFactorRules {
+		for _, keyword := range rule.Keywords {
+			if strings.Contains(text, keyword) {
+				// "ac" terlalu pendek, harus kata utuh
+				if keyword == "ac" && !strings.Contains(text, " ac ") {
+					continue
+				}
+				return rule.Factor, rule.Kind
+			}
+		}
+	}
+	return defaultPowerFactor, "lainnya"
+}
+
+func mcbLoadFor(rec applianceRecord) McbLoad {
+	pf, kind := powerFactorFor(rec.Name, rec.CategoryName)
+	return McbLoad{ID: rec.ID, Name: rec.Name, Watts: rec.Power, PowerFactor: pf, VA: roundTo(rec.Power/pf, 0), Kind: kind}
+}
+
+// scheduledTogether mengecek apakah semua perangkat dijadwalkan menyala di menit yang sama
+func scheduledTogether(records []applianceRecord) (string, bool) {
+	for _, rec := range records {
+		if len(rec.Schedule) == 0 {
+			return "", false
+		}
+	}
+	for minute := 0; minute < minutesPerWeek; minute++ {
+		allActive := true
+		for _, rec := range records {
+			if !isActiveAt(rec.Schedule, minute) {
+				allActive = false
+				break
+			}
+		}
+		if allActive {
+			minuteOfDay := minute % minutesPerDay
+			return fmt.Sprintf("%s %02d:%02d", weekdayLabels[minute/minutesPerDay], minuteOfDay/60, minuteOfDay%60), true
+		}
+	}
+	return "", false
+}
+
+// findRiskyCombinations mencari kombinasi minimal (2-4 perangkat, masing-masing satu unit) yang
+// kalau menyala bersamaan melewati batas peringatan. Minimal = kalau perangkat terkecil dilepas,
+// beban kembali aman, jadi kombinasi yang lebih besar tidak diulang-ulang.
+// Pencarian dipangkas dengan batas atas VA dan jumlah kandidat dibatasi sebelum jadwal dicek,
+// karena scheduledTogether menelusuri semua menit dalam seminggu.
+func findRiskyCombinations(records []applianceRecord, loads []McbLoad, capacityVA int) []RiskyCombination {
+	warningVA := float64(capacityVA) * mcbWarningRatio
+	order := make([]int, len(loads))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return loads[order[a]].VA > loads[order[b]].VA })
+
+	type candidate struct {
+		picked []int
+		total  float64
+	}
+	var candidates []candidate
+	var walk func(start int, picked []int, total float64)
+	walk = func(start int, picked []int, total float64) {
+		if len(candidates) >= maxComboCandidates {
+			return
+		}
+		if len(picked) >= 2 && total >= warningVA {
+			// picked urut VA menurun, jadi elemen terakhir adalah yang terkecil
+			if total-loads[picked[len(picked)-1]].VA < warningVA {
+				candidates = append(candidates, candidate{picked: picked, total: total})
+			}
+			return
+		}
+		remaining := maxComboSize - len(picked)
+		if remaining == 0 {
+			return
+		}
+		for i := start; i < len(order); i++ {
+			idx := order[i]
+			// Satu perangkat saja sudah melewati batas: dilaporkan lewat daftar loads, bukan kombinasi
+			if len(picked) == 0 && loads[idx].VA >= warningVA {
+				continue
+			}
+			// Sisa perangkat tidak lebih besar dari yang ini; kalau semuanya sebesar ini pun tidak sampai batas, berhenti
+			if total+loads[idx].VA*float64(remaining) < warningVA {
+				break
+			}
+			walk(i+1, append(append([]int{}, picked...), idx), total+loads[idx].VA)
+		}
+	}
+	walk(0, nil, 0)
+
+	combos := make([]RiskyCombination, 0, len(candidates))
+	for _, c := range candidates {
+		combo := RiskyCombination{TotalVA: roundTo(c.total, 0), CapacityPct: roundTo(c.total/float64(capacityVA)*100, 1), WillTrip: c.total > float64(capacityVA)}
+		var members []applianceRecord
+		for _, idx := range c.picked {
+			combo.Devices = append(combo.Devices, loads[idx].Name)
+			members = append(members, records[idx])
+		}
+		combo.ScheduledAt, combo.Scheduled = scheduledTogether(members)
+		combos = append(combos, combo)
+	}
+
+	// Yang memang terjadwal bersamaan lebih penting, lalu beban terbesar
+	sort.SliceStable(combos, func(i, j int) bool {
+		if combos[i].Scheduled != combos[j].Scheduled {
+			return combos[i].Scheduled
+		}
+		return combos[i].TotalVA > combos[j].TotalVA
+	})
+	if len(combos) > maxRiskyCombos {
+		combos = combos[:maxRiskyCombos]
+	}
+	return combos
+}
+
+// --- Beban live dari IoT (diisi oleh syncAndNotify) ---
+
+type liveReading struct {
+	VA    float64
+	Watts float64
+	At    time.Time
+}
+
+// liveUserLoad: pembacaan terbaru per sensor. Hanya meter utama yang dijumlahkan sebagai beban rumah;
+// colokan pintar yang ditautkan ke perangkat sudah termasuk di dalamnya.
+type liveUserLoad struct {
+	Sensors     map[string]liveReading
+	MainSensors map[string]bool
+	CapacityVA  int
+	CapacityAt  time.Time
+}
+
+var liveLoads = struct {
+	sync.Mutex
+	byUser map[int]*liveUserLoad
+}{byUser: make(map[int]*liveUserLoad)}
+
+// userCapacityVA: daya tersambung dari submit terakhir user
+func userCapacityVA(userID int) int {
+	var besarListrik string
+	err := db.DB.QueryRow(`
+		SELECT COALESCE(besar_listrik, '') FROM riwayat_perangkat
+		WHERE user_id = ? AND deleted_at IS NULL
+		ORDER BY tanggal_input DESC, id DESC LIMIT 1`, userID).Scan(&besarListrik)
+	if err != nil {
+		return 0
+	}
+	va, _ := parseCapacityVA(besarListrik)
+	return va
+}
+
+// recordLiveLoad menyimpan pembacaan sensor terbaru dan mengembalikan total beban live user
+func recordLiveLoad(data SyncData, now time.Time) (LiveLoadStatus, int) {
+	va := data.Voltase * data.Ampere
+	if va <= 0 {
+		va = data.Watt / defaultPowerFactor
+	}
+
+	// Query daya tersambung dan meter utama dijalankan di luar lock supaya sync user lain tidak ikut menunggu DB
+	liveLoads.Lock()
+	user, exists := liveLoads.byUser[data.UserID]
+	refresh := !exists || now.Sub(user.CapacityAt) > liveCapacityTTL
+	liveLoads.Unlock()
+	capacityVA := 0
+	var roles sensorRoles
+	if refresh {
+		capacityVA = userCapacityVA(data.UserID)
+		var err error
+		if roles, err = loadSensorRoles(data.UserID); err != nil {
+			log.Printf("⚠️ recordLiveLoad: Gagal memuat peran sensor: %v", err)
+		}
+	}
+
+	liveLoads.Lock()
+	defer liveLoads.Unlock()
+	user, exists = liveLoads.byUser[data.UserID]
+	if !exists {
+		user = &liveUserLoad{Sensors: make(map[string]liveReading)}
+		liveLoads.byUser[data.UserID] = user
+	}
+	user.Sensors[data.DeviceLabel] = liveReading{VA: va, Watts: data.Watt, At: now}
+	if refresh {
+		user.CapacityVA = capacityVA
+		user.MainSensors = roles.Main
+		user.CapacityAt = now
+	}
+	return summarizeLiveLoad(user, now), user.CapacityVA
+}
+
+func summarizeLiveLoad(user *liveUserLoad, now time.Time) LiveLoadStatus {
+	var status LiveLoadStatus
+	var latest time.Time
+	for label, reading := range user.Sensors {
+		if !user.MainSensors[label] || now.Sub(reading.At) > liveLoadTTL {
+			continue
+		}
+		status.VA += reading.VA
+		status.Watts += reading.Watts
+		status.Sensors++
+		if reading.At.After(latest) {
+			latest = reading.At
+		}
+	}
+	if status.Sensors > 0 {
+		status.UpdatedAt = latest.Format(time.RFC3339)
+	}
+	status.VA = roundTo(status.VA, 0)
+	status.Watts = roundTo(status.Watts, 1)
+	if user.CapacityVA > 0 {
+		status.CapacityPct = roundTo(status.VA/float64(user.CapacityVA)*100, 1)
+	}
+	return status
+}
+
+func currentLiveLoad(userID int) *LiveLoadStatus {
+	liveLoads.Lock()
+	defer liveLoads.Unlock()
+	user, exists := liveLoads.byUser[userID]
+	if !exists {
+		return nil
+	}
+	status := summarizeLiveLoad(user, time.Now())
+	if status.Sensors == 0 {
+		return nil
+	}
+	return &status
+}
+
+// checkLiveLoad dipanggil tiap data IoT masuk; kirim peringatan kalau beban mendekati batas MCB.
+// Notifikasi dibatasi satu kali per 30 menit.
+func checkLiveLoad(app *firebase.App, data SyncData) {
+	now := time.Now()
+	status, capacityVA := recordLiveLoad(data, now)
+	if capacityVA <= 0 || status.VA < float64(capacityVA)*mcbWarningRatio {
+		return
+	}
+
+	bucket := now.Truncate(liveAlertBucket).Format("2006-01-02T15:04")
+	title := "Beban Listrik Hampir Penuh"
+	body := fmt.Sprintf("Beban saat ini %.0f VA (%.0f%% dari %d VA). Matikan sebagian perangkat agar MCB tidak turun.",
+		status.VA, status.CapacityPct, capacityVA)
+	if status.VA > float64(capacityVA) {
+		title = "Beban Listrik Melebihi Daya!"
+	}
+	go notifyUserOnce(app, data.UserID, "mcb_load", bucket, title, body)
+}
+
+// McbRiskHandler menganalisis risiko MCB turun: puncak beban terjadwal, kombinasi rawan, dan beban live
+func McbRiskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	records, err := latestSubmissionRecords(userID)
+	if err != nil {
+		log.Printf("❌ McbRiskHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, `{"error": "Belum ada data perangkat"}`, http.StatusNotFound)
+		return
+	}
+
+	besarListrik := records[0].BesarListrik
+	capacityVA, ok := parseCapacityVA(besarListrik)
+	if !ok || capacityVA <= 0 {
+		http.Error(w, `{"error": "Besar listrik tidak dikenali"}`, http.StatusBadRequest)
+		return
+	}
+
+	response := McbRiskResponse{
+		BesarListrik: besarListrik,
+		CapacityVA:   capacityVA,
+		WarningVA:    roundTo(float64(capacityVA)*mcbWarningRatio, 0),
+		Loads:        []McbLoad{},
+	}
+
+	// Puncak beban terjadwal dalam VA (semua unit ikut dihitung)
+	var scheduled []scheduledLoad
+	for _, rec := range records {
+		load := mcbLoadFor(rec)
+		response.Loads = append(response.Loads, load)
+		scheduled = append(scheduled, scheduledLoad{
+			ID: rec.ID, Name: rec.Name, Watts: load.VA * float64(rec.Quantity), Schedule: rec.Schedule,
+		})
+	}
+	peak := estimatePeakLoad(scheduled)
+	response.ScheduledPeakVA = roundTo(peak.PeakWatts, 0)
+	if peak.PeakWatts > 0 {
+		response.ScheduledPeakAt = peak.PeakDay + " " + peak.PeakTime
+	}
+	response.ScheduledPeakPct = roundTo(peak.PeakWatts/float64(capacityVA)*100, 1)
+
+	response.RiskyCombinations = findRiskyCombinations(records, response.Loads, capacityVA)
+	if response.RiskyCombinations == nil {
+		response.RiskyCombinations = []RiskyCombination{}
+	}
+	response.Live = currentLiveLoad(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
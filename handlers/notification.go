@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"context"
+	"log"
+
+	firebase "firebase.google.com/go"
+)
+
+// claimNotification mencatat notifikasi (jenis + kunci) untuk user.
+// Mengembalikan true hanya untuk pemanggil pertama, jadi notifikasi yang sama tidak dikirim dua kali.
+func claimNotification(userID int, kind, key string) bool {
+	result, err := db.DB.Exec(`
+		INSERT IGNORE INTO notifikasi_terkirim (user_id, jenis, kunci) VALUES (?, ?, ?)`,
+		userID, kind, key)
+	if err != nil {
+		log.Printf("❌ Gagal mencatat notifikasi %s user %d: %v", kind, userID, err)
+		return false
+	}
+	inserted, _ := result.RowsAffected()
+	return inserted > 0
+}
+
+// notifyUserOnce mengirim push notification sekali saja per (jenis, kunci)
+func notifyUserOnce(app *firebase.App, userID int, kind, key, title, body string) {
+	token := getUserFcmTokenFromDB(userID)
+	if token == "" {
+		return
+	}
+	if !claimNotification(userID, kind, key) {
+		return
+	}
+	log.Printf("🔔 Sending %s Notification to User %d: %s", kind, userID, title)
+	sendNotification(context.Background(), app, token, title, body)
+}
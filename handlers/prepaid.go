@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	firebase "firebase.google.com/go"
+)
+
+const (
+	// PrepaidLowBalanceDays: peringatan dikirim kalau token diperkirakan habis dalam sekian hari
+	PrepaidLowBalanceDays = 3
+	// measuredWindowDays: rentang data IoT yang dipakai untuk menghitung rata-rata konsumsi harian
+	measuredWindowDays = 7
+	// minMeasuredSpan: data IoT minimal mencakup rentang ini supaya rata-ratanya bisa dipercaya
+	minMeasuredSpan = 12 * time.Hour
+)
+
+type TokenPurchase struct {
+	ID          int     `json:"id"`
+	Amount      float64 `json:"amount"`
+	Kwh         float64 `json:"kwh"`
+	Token       string  `json:"token"`
+	PurchasedAt string  `json:"date"`
+}
+
+type MeterReading struct {
+	ID     int     `json:"id"`
+	Kwh    float64 `json:"kwh"`
+	ReadAt string  `json:"date"`
+	Source string  `json:"source"`
+}
+
+type PrepaidForecastResponse struct {
+	BalanceKwh       float64       `json:"balance_kwh"`
+	LastReading      *MeterReading `json:"last_reading"`
+	TopUpKwhSince    float64       `json:"top_up_kwh_since_reading"`
+	UsedKwhSince     float64       `json:"used_kwh_since_reading"`
+	DailyKwh         float64       `json:"daily_kwh"`
+	ConsumptionBasis string        `json:"consumption_source"`
+	DaysRemaining    *float64      `json:"days_remaining"`
+	RunOutDate       *string       `json:"run_out_date"`
+	LowBalance       bool          `json:"low_balance"`
+}
+
+// isPrepaidBilling: jenis pembayaran prabayar (token) dari aplikasi bisa ditulis beberapa cara
+func isPrepaidBilling(billingType string) bool {
+	switch strings.ToLower(strings.TrimSpace(billingType)) {
+	case "prepaid", "prabayar", "token":
+		return true
+	}
+	return false
+}
+
+// normalizeTokenNumber membuang spasi/strip dari nomor token; token PLN selalu 20 digit
+func normalizeTokenNumber(token string) (string, bool) {
+	cleaned := strings.NewReplacer(" ", "", "-", "").Replace(token)
+	if len(cleaned) != 20 {
+		return "", false
+	}
+	for _, ch := range cleaned {
+		if ch < '0' || ch > '9' {
+			return "", false
+		}
+	}
+	return cleaned, true
+}
+
+// parseEventTime menerima "YYYY-MM-DD" atau RFC3339; kosong berarti sekarang
+func parseEventTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// measuredDailyKWh menghitung rata-rata kWh per hari dari meter utama sejak tanggal tertentu
+func measuredDailyKWh(userID int, since time.Time) (float64, bool) {
+	energy, err := houseEnergy(userID, since, time.Now(), false)
+	if err != nil {
+		log.Printf("❌ measuredDailyKWh: Error querying energy_logs: %v", err)
+		return 0, false
+	}
+
+	total := 0.0
+	found := false
+	for _, e := range energy {
+		span := e.Last.Sub(e.First)
+		if span < minMeasuredSpan {
+			continue
+		}
+		total += e.Kwh / span.Hours() * 24
+		found = true
+	}
+	return total, found
+}
+
+// estimatedDailyKWh: rata-rata kWh per hari dari perangkat pada submit terakhir
+func estimatedDailyKWh(userID int) (float64, error) {
+	records, err := latestSubmissionRecords(userID)
+	if err != nil {
+		return 0, err
+	}
+	total := 0.0
+	for _, rec := range records {
+		total += rec.averageDailyKWh()
+	}
+	return total, nil
+}
+
+// buildPrepaidForecast menghitung sisa kWh sekarang dan tanggal token habis.
+// Titik awal = pembacaan meter terakhir (atau pembelian token pertama kalau belum pernah baca meter),
+// ditambah token yang dibeli sesudahnya, dikurangi konsumsi sejak titik awal.
+func buildPrepaidForecast(userID int, now time.Time) (*PrepaidForecastResponse, error) {
+	var anchorKwh float64
+	var anchorTime time.Time
+	var lastReading *MeterReading
+
+	var reading MeterReading
+	var readAt time.Time
+	err := db.DB.QueryRow(`
+		SELECT id, sisa_kwh, tanggal_baca, sumber FROM saldo_meter
+		WHERE user_id = ? ORDER BY tanggal_baca DESC, id DESC LIMIT 1`, userID).
+		Scan(&reading.ID, &reading.Kwh, &readAt, &reading.Source)
+	switch {
+	case err == nil:
+		reading.ReadAt = readAt.Format(time.RFC3339)
+		lastReading = &reading
+		anchorKwh, anchorTime = reading.Kwh, readAt
+	case err == sql.ErrNoRows:
+		var firstAt time.Time
+		err = db.DB.QueryRow(`
+			SELECT tanggal_beli FROM token_listrik
+			WHERE user_id = ? ORDER BY tanggal_beli, id LIMIT 1`, userID).Scan(&firstAt)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Tanpa pembacaan meter, anggap saldo sebelum token pertama kosong
+		anchorTime = firstAt.Add(-time.Second)
+	default:
+		return nil, err
+	}
+
+	forecast := &PrepaidForecastResponse{LastReading: lastReading}
+	err = db.DB.QueryRow(`
+		SELECT COALESCE(SUM(kwh), 0) FROM token_listrik
+		WHERE user_id = ? AND tanggal_beli > ? AND tanggal_beli <= ?`, userID, anchorTime, now).
+		Scan(&forecast.TopUpKwhSince)
+	if err != nil {
+		return nil, err
+	}
+
+	if daily, ok := measuredDailyKWh(userID, now.AddDate(0, 0, -measuredWindowDays)); ok {
+		forecast.DailyKwh, forecast.ConsumptionBasis = daily, "iot"
+	} else {
+		daily, err := estimatedDailyKWh(userID)
+		if err != nil {
+			return nil, err
+		}
+		forecast.DailyKwh, forecast.ConsumptionBasis = daily, "estimated"
+	}
+
+	elapsedDays := now.Sub(anchorTime).Hours() / 24
+	forecast.UsedKwhSince = roundTo(forecast.DailyKwh*math.Max(elapsedDays, 0), 2)
+	forecast.BalanceKwh = roundTo(math.Max(anchorKwh+forecast.TopUpKwhSince-forecast.UsedKwhSince, 0), 2)
+	forecast.DailyKwh = roundTo(forecast.DailyKwh, 2)
+
+	if forecast.DailyKwh > 0 {
+		days := roundTo(forecast.BalanceKwh/forecast.DailyKwh, 1)
+		runOut := now.Add(time.Duration(days * 24 * float64(time.Hour))).Format("2006-01-02")
+		forecast.DaysRemaining = &days
+		forecast.RunOutDate = &runOut
+		forecast.LowBalance = days <= PrepaidLowBalanceDays
+	}
+	return forecast, nil
+}
+
+// recordMeterReading menyimpan sisa kWh di meter (dipanggil dari endpoint dan dari submit prabayar)
+func recordMeterReading(execer sqlExecer, userID int, kwh float64, readAt time.Time, source string) (int64, error) {
+	result, err := execer.Exec(`
+		INSERT INTO saldo_meter (user_id, sisa_kwh, tanggal_baca, sumber) VALUES (?, ?, ?, ?)`,
+		userID, kwh, readAt, source)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// PrepaidTokensHandler: GET riwayat pembelian token, POST catat pembelian token baru
+func PrepaidTokensHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.DB.Query(`
+			SELECT id, nominal_rp, kwh, nomor_token, tanggal_beli FROM token_listrik
+			WHERE user_id = ? ORDER BY tanggal_beli DESC, id DESC`, userID)
+		if err != nil {
+			log.Printf("❌ PrepaidTokensHandler: Error querying tokens: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data token"}`, http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		purchases := []TokenPurchase{}
+		for rows.Next() {
+			var purchase TokenPurchase
+			var purchasedAt time.Time
+			if err := rows.Scan(&purchase.ID, &purchase.Amount, &purchase.Kwh, &purchase.Token, &purchasedAt); err != nil {
+				log.Printf("❌ PrepaidTokensHandler: Error scanning token: %v", err)
+				http.Error(w, `{"error": "Gagal membaca data token"}`, http.StatusInternalServerError)
+				return
+			}
+			purchase.PurchasedAt = purchasedAt.Format(time.RFC3339)
+			purchases = append(purchases, purchase)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(purchases)
+
+	case http.MethodPost:
+		var input TokenPurchase
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if input.Amount <= 0 || input.Kwh <= 0 {
+			http.Error(w, `{"error": "Nominal dan kWh token harus lebih besar dari 0"}`, http.StatusBadRequest)
+			return
+		}
+		token, valid := normalizeTokenNumber(input.Token)
+		if !valid {
+			http.Error(w, `{"error": "Nomor token harus 20 digit"}`, http.StatusBadRequest)
+			return
+		}
+		purchasedAt, err := parseEventTime(input.PurchasedAt)
+		if err != nil || purchasedAt.After(time.Now()) {
+			http.Error(w, `{"error": "Tanggal pembelian tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.DB.Exec(`
+			INSERT INTO token_listrik (user_id, nominal_rp, kwh, nomor_token, tanggal_beli)
+			VALUES (?, ?, ?, ?, ?)`, userID, input.Amount, input.Kwh, token, purchasedAt)
+		if err != nil {
+			log.Printf("❌ PrepaidTokensHandler: Gagal menyimpan token: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan token"}`, http.StatusInternalServerError)
+			return
+		}
+		id, _ := result.LastInsertId()
+
+		log.Printf("✅ Token %.1f kWh (Rp %.0f) dicatat untuk user %d", input.Kwh, input.Amount, userID)
+		input.ID = int(id)
+		input.Token = token
+		input.PurchasedAt = purchasedAt.Format(time.RFC3339)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(input)
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// PrepaidReadingsHandler: GET riwayat pembacaan sisa kWh, POST catat pembacaan baru dari meter
+func PrepaidReadingsHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit := 100
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			if parsed, err := strconv.Atoi(limitParam); err == nil && parsed > 0 && parsed <= 500 {
+				limit = parsed
+			}
+		}
+		rows, err := db.DB.Query(`
+			SELECT id, sisa_kwh, tanggal_baca, sumber FROM saldo_meter
+			WHERE user_id = ? ORDER BY tanggal_baca DESC, id DESC LIMIT ?`, userID, limit)
+		if err != nil {
+			log.Printf("❌ PrepaidReadingsHandler: Error querying readings: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data saldo meter"}`, http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		readings := []MeterReading{}
+		for rows.Next() {
+			var reading MeterReading
+			var readAt time.Time
+			if err := rows.Scan(&reading.ID, &reading.Kwh, &readAt, &reading.Source); err != nil {
+				log.Printf("❌ PrepaidReadingsHandler: Error scanning reading: %v", err)
+				http.Error(w, `{"error": "Gagal membaca data saldo meter"}`, http.StatusInternalServerError)
+				return
+			}
+			reading.ReadAt = readAt.Format(time.RFC3339)
+			readings = append(readings, reading)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(readings)
+
+	case http.MethodPost:
+		var input MeterReading
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if input.Kwh < 0 {
+			http.Error(w, `{"error": "Sisa kWh tidak boleh negatif"}`, http.StatusBadRequest)
+			return
+		}
+		readAt, err := parseEventTime(input.ReadAt)
+		if err != nil || readAt.After(time.Now()) {
+			http.Error(w, `{"error": "Tanggal pembacaan tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+
+		id, err := recordMeterReading(db.DB, userID, input.Kwh, readAt, "manual")
+		if err != nil {
+			log.Printf("❌ PrepaidReadingsHandler: Gagal menyimpan saldo meter: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan saldo meter"}`, http.StatusInternalServerError)
+			return
+		}
+
+		input.ID = int(id)
+		input.ReadAt = readAt.Format(time.RFC3339)
+		input.Source = "manual"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(input)
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// PrepaidForecastHandler memperkirakan sisa kWh dan tanggal token habis
+func PrepaidForecastHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	forecast, err := buildPrepaidForecast(userID, time.Now())
+	if err != nil {
+		log.Printf("❌ PrepaidForecastHandler: Error building forecast: %v", err)
+		http.Error(w, `{"error": "Gagal menghitung perkiraan token"}`, http.StatusInternalServerError)
+		return
+	}
+	if forecast == nil {
+		http.Error(w, `{"error": "Belum ada data token atau saldo meter"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(forecast)
+}
+
+// checkPrepaidBalances mengirim peringatan ke user prabayar yang tokennya hampir habis.
+// Kunci notifikasi = pembelian token terakhir, jadi peringatan muncul lagi hanya setelah isi ulang.
+func checkPrepaidBalances(app *firebase.App) {
+	rows, err := db.DB.Query(`
+		SELECT user_id FROM saldo_meter
+		UNION
+		SELECT user_id FROM token_listrik`)
+	if err != nil {
+		log.Printf("❌ [PREPAID] Gagal mengambil daftar user prabayar: %v", err)
+		return
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err == nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		forecast, err := buildPrepaidForecast(userID, now)
+		if err != nil {
+			log.Printf("❌ [PREPAID] Gagal menghitung perkiraan user %d: %v", userID, err)
+			continue
+		}
+		if forecast == nil || !forecast.LowBalance {
+			continue
+		}
+
+		var lastTokenID int
+		db.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM token_listrik WHERE user_id = ?`, userID).Scan(&lastTokenID)
+		notifyUserOnce(app, userID, "prepaid_low", strconv.Itoa(lastTokenID),
+			"Token Listrik Hampir Habis",
+			fmt.Sprintf("Sisa sekitar %.1f kWh, diperkirakan habis %s. Segera isi ulang token.",
+				forecast.BalanceKwh, *forecast.RunOutDate))
+	}
+}
+
+// StartPrepaidAlertScheduler memeriksa saldo token semua user prabayar setiap interval
+func StartPrepaidAlertScheduler(app *firebase.App, interval time.Duration) {
+	go func() {
+		log.Printf("⏰ Cek saldo token prabayar dimulai, setiap %v...", interval)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkPrepaidBalances(app)
+		}
+	}()
+}
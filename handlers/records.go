@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"EnerTrack-BE/models"
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+)
+
+// sqlExecer: *sql.DB atau *sql.Tx, supaya helper simpan data bisa ikut transaksi submit
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// applianceRecord adalah satu baris riwayat_perangkat (yang belum dihapus) dalam bentuk siap hitung
+type applianceRecord struct {
+	ID           int
+	IDSubmit     string
+	InputDate    time.Time
+	Name         string
+	Brand        string
+	CategoryID   *int
+	CategoryName string
+	Power        float64
+	Duration     float64
+	Quantity     int
+	BesarListrik string
+	RoomID       *int
+	RoomName     string
+	Schedule     []models.UsageWindow
+}
+
+// loadApplianceRecords mengambil riwayat perangkat user beserta jadwalnya, urut dari yang terlama.
+// Kalau idSubmits diisi, hanya batch tersebut yang diambil.
+func loadApplianceRecords(userID int, idSubmits ...string) ([]applianceRecord, error) {
+	query := `
+		SELECT rp.id, rp.id_submit, rp.tanggal_input, rp.nama_perangkat, COALESCE(rp.merek, ''),
+			   rp.kategori_id, COALESCE(k.nama_kategori, ''), rp.daya, COALESCE(rp.durasi, 0),
+			   COALESCE(rp.jumlah, 1), COALESCE(rp.besar_listrik, ''), r.id, COALESCE(r.nama_ruangan, '')
+		FROM riwayat_perangkat rp
+		LEFT JOIN kategori k ON rp.kategori_id = k.kategori_id
+		LEFT JOIN ruangan r ON rp.ruangan_id = r.id AND r.user_id = rp.user_id
+		WHERE rp.user_id = ? AND rp.deleted_at IS NULL`
+	args := []interface{}{userID}
+	if len(idSubmits) > 0 {
+		query += " AND rp.id_submit IN (?" + strings.Repeat(", ?", len(idSubmits)-1) + ")"
+		for _, idSubmit := range idSubmits {
+			args = append(args, idSubmit)
+		}
+	}
+	query += " ORDER BY rp.tanggal_input, rp.id"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules, err := loadUserSchedules(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []applianceRecord
+	for rows.Next() {
+		var rec applianceRecord
+		var categoryID, roomID sql.NullInt64
+		if err := rows.Scan(&rec.ID, &rec.IDSubmit, &rec.InputDate, &rec.Name, &rec.Brand,
+			&categoryID, &rec.CategoryName, &rec.Power, &rec.Duration,
+			&rec.Quantity, &rec.BesarListrik, &roomID, &rec.RoomName); err != nil {
+			return nil, err
+		}
+		if categoryID.Valid {
+			id := int(categoryID.Int64)
+			rec.CategoryID = &id
+		}
+		if roomID.Valid {
+			id := int(roomID.Int64)
+			rec.RoomID = &id
+		}
+		rec.Quantity = normalizeQuantity(rec.Quantity)
+		rec.Schedule = schedules[rec.ID]
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// averageDailyKWh: rata-rata kWh per hari (semua unit), mengikuti jadwal kalau ada
+func (rec applianceRecord) averageDailyKWh() float64 {
+	return rec.Power * averageDailyHours(rec.Schedule, rec.Duration) * float64(rec.Quantity) / 1000.0
+}
+
+// dailyKWhOn: kWh pada hari tertentu (jadwal per hari bisa beda-beda)
+func (rec applianceRecord) dailyKWhOn(weekday time.Weekday) float64 {
+	return rec.Power * dailyUsageHours(rec.Schedule, weekday, rec.Duration) * float64(rec.Quantity) / 1000.0
+}
+
+// identityKey dipakai untuk mencocokkan perangkat yang sama antar submit (nama + merek)
+func (rec applianceRecord) identityKey() string {
+	return strings.ToLower(strings.TrimSpace(rec.Name)) + "|" + strings.ToLower(strings.TrimSpace(rec.Brand))
+}
+
+// roundTo membulatkan ke sejumlah angka di belakang koma (untuk respons JSON)
+func roundTo(value float64, places int) float64 {
+	factor := math.Pow(10, float64(places))
+	return math.Round(value*factor) / factor
+}
+
+// latestSubmissionRecords: perangkat pada batch submit terakhir user (inventaris yang sedang dipakai)
+func latestSubmissionRecords(userID int) ([]applianceRecord, error) {
+	records, err := loadApplianceRecords(userID)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	latestID := records[len(records)-1].IDSubmit
+	var latest []applianceRecord
+	for _, rec := range records {
+		if rec.IDSubmit == latestID {
+			latest = append(latest, rec)
+		}
+	}
+	return latest, nil
+}
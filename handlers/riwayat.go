@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"EnerTrack-BE/db"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Definisikan struct untuk respons JSON agar cocok dengan model di Android
@@ -19,6 +24,8 @@ type HistoryItemResponse struct {
 	HouseCapacity    string  `json:"besar_listrik"`
 	Power            float64 `json:"daya"`
 	Usage            float64 `json:"durasi"`
+	Quantity         int     `json:"jumlah"`
+	RoomName         string  `json:"nama_ruangan"`
 	// Nama JSON "dailyEnergy" harus cocok dengan yang diharapkan GSON di Android
 	DailyKwh float64 `json:"dailyEnergy"`
 }
@@ -29,7 +36,112 @@ type DeviceOption struct {
 	Context string `json:"context"` // String lengkap untuk AI (misal: "AC Kamar (Samsung), 400 Watt...")
 }
 
-// GetDeviceHistoryHandler mengambil seluruh riwayat perangkat user
+// HistoryPageResponse: respons riwayat dengan pagination (dipakai kalau ada parameter query)
+type HistoryPageResponse struct {
+	Items      []HistoryItemResponse `json:"items"`
+	NextCursor *string               `json:"next_cursor"`
+	Total      int                   `json:"total"`
+	Limit      int                   `json:"limit"`
+	Sort       string                `json:"sort"`
+}
+
+// historySort: kolom urutan + arah. rp.id selalu jadi tie-breaker supaya urutan stabil.
+type historySort struct {
+	Column string
+	Desc   bool
+}
+
+var historySorts = map[string]historySort{
+	"date_desc":   {"rp.tanggal_input", true},
+	"date_asc":    {"rp.tanggal_input", false},
+	"power_desc":  {"rp.daya", true},
+	"power_asc":   {"rp.daya", false},
+	"energy_desc": {"(rp.daya * COALESCE(rp.durasi, 0) * rp.jumlah)", true},
+	"energy_asc":  {"(rp.daya * COALESCE(rp.durasi, 0) * rp.jumlah)", false},
+	"name_asc":    {"rp.nama_perangkat", false},
+	"name_desc":   {"rp.nama_perangkat", true},
+}
+
+// historyCursor menyimpan posisi baris terakhir halaman sebelumnya (keyset pagination)
+type historyCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+var historyQueryParams = []string{"limit", "cursor", "sort", "from", "to", "category_id", "brand", "q", "besar_listrik"}
+
+func encodeHistoryCursor(cursor historyCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeHistoryCursor(value string) (historyCursor, error) {
+	var cursor historyCursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
+
+// buildHistoryFilters menerjemahkan parameter filter jadi kondisi WHERE tambahan
+func buildHistoryFilters(params url.Values) ([]string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	if from := params.Get("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			return nil, nil, fmt.Errorf("format from tidak valid, gunakan YYYY-MM-DD")
+		}
+		conditions = append(conditions, "DATE(rp.tanggal_input) >= ?")
+		args = append(args, from)
+	}
+	if to := params.Get("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			return nil, nil, fmt.Errorf("format to tidak valid, gunakan YYYY-MM-DD")
+		}
+		conditions = append(conditions, "DATE(rp.tanggal_input) <= ?")
+		args = append(args, to)
+	}
+	if categoryParam := params.Get("category_id"); categoryParam != "" {
+		// category_id=0 untuk perangkat tanpa kategori
+		categoryID, err := strconv.Atoi(categoryParam)
+		if err != nil {
+			return nil, nil, fmt.Errorf("category_id tidak valid")
+		}
+		if categoryID == 0 {
+			conditions = append(conditions, "rp.kategori_id IS NULL")
+		} else {
+			conditions = append(conditions, "rp.kategori_id = ?")
+			args = append(args, categoryID)
+		}
+	}
+	if brand := strings.TrimSpace(params.Get("brand")); brand != "" {
+		conditions = append(conditions, "rp.merek = ?")
+		args = append(args, brand)
+	}
+	if search := strings.TrimSpace(params.Get("q")); search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+		conditions = append(conditions, "rp.nama_perangkat LIKE ?")
+		args = append(args, "%"+escaped+"%")
+	}
+	if capacity := strings.TrimSpace(params.Get("besar_listrik")); capacity != "" {
+		conditions = append(conditions, "rp.besar_listrik = ?")
+		args = append(args, capacity)
+	}
+	return conditions, args, nil
+}
+
+// GetDeviceHistoryHandler mengambil riwayat perangkat user.
+// Tanpa parameter query, respons tetap array penuh (kompatibel dengan aplikasi lama).
+// Dengan limit/cursor/sort/filter, respons berupa HistoryPageResponse.
 func GetDeviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Ambil sesi
 	session, err := Store.Get(r, "elektronik_rumah_session")
@@ -46,24 +158,91 @@ func GetDeviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
+	params := r.URL.Query()
+	paginated := false
+	for _, name := range historyQueryParams {
+		if params.Has(name) {
+			paginated = true
+			break
+		}
+	}
+
+	sortName := params.Get("sort")
+	if sortName == "" {
+		sortName = "date_desc"
+	}
+	sortBy, validSort := historySorts[sortName]
+	if !validSort {
+		http.Error(w, `{"error": "Parameter sort tidak valid"}`, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if limitParam := params.Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error": "Parameter limit tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if limit > maxHistoryLimit {
+			limit = maxHistoryLimit
+		}
+	}
+
+	conditions, filterArgs, err := buildHistoryFilters(params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	whereClause := "rp.user_id = ? AND rp.deleted_at IS NULL"
+	for _, condition := range conditions {
+		whereClause += " AND " + condition
+	}
+	filterArgs = append([]interface{}{userID}, filterArgs...)
+
+	direction, comparator := "ASC", ">"
+	if sortBy.Desc {
+		direction, comparator = "DESC", "<"
+	}
+
+	pageClause := whereClause
+	pageArgs := append([]interface{}{}, filterArgs...)
+	if cursorParam := params.Get("cursor"); cursorParam != "" {
+		cursor, err := decodeHistoryCursor(cursorParam)
+		if err != nil || cursor.Sort != sortName {
+			http.Error(w, `{"error": "Cursor tidak valid untuk urutan ini"}`, http.StatusBadRequest)
+			return
+		}
+		pageClause += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND rp.id %[2]s ?))", sortBy.Column, comparator)
+		pageArgs = append(pageArgs, cursor.Value, cursor.Value, cursor.ID)
+	}
+
+	query := fmt.Sprintf(`
 		SELECT 
 			rp.id, 
 			rp.tanggal_input, 
 			rp.nama_perangkat, 
 			rp.merek, 
-			rp.kategori_id, 
-			k.nama_kategori,
-			rp.besar_listrik,
+			COALESCE(rp.kategori_id, 0), 
+			COALESCE(k.nama_kategori, ''),
+			COALESCE(rp.besar_listrik, ''),
 			rp.daya, 
-			rp.durasi
+			COALESCE(rp.durasi, 0),
+			COALESCE(rp.jumlah, 1),
+			COALESCE(r.nama_ruangan, ''),
+			CAST(%[1]s AS CHAR)
 		FROM riwayat_perangkat rp
 		LEFT JOIN kategori k ON rp.kategori_id = k.kategori_id
-		WHERE rp.user_id = ?
-		ORDER BY rp.tanggal_input DESC, rp.id DESC
-	`
+		LEFT JOIN ruangan r ON rp.ruangan_id = r.id
+		WHERE %[2]s
+		ORDER BY %[1]s %[3]s, rp.id %[3]s
+	`, sortBy.Column, pageClause, direction)
+	if paginated {
+		// Ambil satu baris lebih untuk tahu masih ada halaman berikutnya atau tidak
+		query += fmt.Sprintf(" LIMIT %d", limit+1)
+	}
 
-	rows, err := db.DB.Query(query, userID)
+	rows, err := db.DB.Query(query, pageArgs...)
 	if err != nil {
 		log.Printf("❌ Error querying device history: %v", err)
 		http.Error(w, `{"error": "Gagal mengambil data riwayat"}`, http.StatusInternalServerError)
@@ -71,23 +250,27 @@ func GetDeviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var historyItems []HistoryItemResponse
+	historyItems := []HistoryItemResponse{}
+	var sortKeys []string
 	for rows.Next() {
 		var item HistoryItemResponse
+		var sortKey string
 		if err := rows.Scan(
 			&item.ID, &item.Date, &item.Appliance, &item.ApplianceDetails,
 			&item.CategoryID, &item.CategoryName, &item.HouseCapacity,
-			&item.Power, &item.Usage,
+			&item.Power, &item.Usage, &item.Quantity, &item.RoomName, &sortKey,
 		); err != nil {
 			log.Printf("❌ Error scanning history row: %v", err)
 			http.Error(w, `{"error": "Gagal membaca data riwayat"}`, http.StatusInternalServerError)
 			return
 		}
 
-		// Hitung manual daily kWh
-		item.DailyKwh = (item.Power * item.Usage) / 1000.0
+		// Hitung manual daily kWh (dikali jumlah unit)
+		item.Quantity = normalizeQuantity(item.Quantity)
+		item.DailyKwh = (item.Power * item.Usage * float64(item.Quantity)) / 1000.0
 
 		historyItems = append(historyItems, item)
+		sortKeys = append(sortKeys, sortKey)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -97,8 +280,43 @@ func GetDeviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if !paginated {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(historyItems)
+		return
+	}
+
+	response := HistoryPageResponse{Limit: limit, Sort: sortName}
+	if len(historyItems) > limit {
+		historyItems = historyItems[:limit]
+		lastID, _ := strconv.Atoi(historyItems[limit-1].ID)
+		nextCursor := encodeHistoryCursor(historyCursor{Sort: sortName, Value: sortKeys[limit-1], ID: lastID})
+		response.NextCursor = &nextCursor
+	}
+	response.Items = historyItems
+
+	countQuery := "SELECT COUNT(*) FROM riwayat_perangkat rp WHERE " + whereClause
+	if err := db.DB.QueryRow(countQuery, filterArgs...).Scan(&response.Total); err != nil {
+		log.Printf("❌ Error counting device history: %v", err)
+		http.Error(w, `{"error": "Gagal menghitung data riwayat"}`, http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(historyItems)
+	json.NewEncoder(w).Encode(response)
+}
+
+// formatDurasi: 0.25 -> "15 menit", 8 -> "8 jam", 1.5 -> "1 jam 30 menit"
+func formatDurasi(hours float64) string {
+	h, m := splitHoursMinutes(hours)
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%d menit", m)
+	case m == 0:
+		return fmt.Sprintf("%d jam", h)
+	default:
+		return fmt.Sprintf("%d jam %d menit", h, m)
+	}
 }
 
 // GetUniqueDevicesHandler mengambil daftar perangkat unik (untuk Dropdown Chat)
@@ -119,9 +337,9 @@ func GetUniqueDevicesHandler(w http.ResponseWriter, r *http.Request) {
 	// 2. Query ambil nama, merek, daya, durasi dari riwayat
 	// Kita urutkan ID DESC biar dapet data settingan terakhir user untuk alat tersebut
 	query := `
-		SELECT nama_perangkat, merek, daya, durasi 
+		SELECT nama_perangkat, merek, daya, durasi, COALESCE(jumlah, 1)
 		FROM riwayat_perangkat 
-		WHERE user_id = ? 
+		WHERE user_id = ? AND deleted_at IS NULL
 		ORDER BY id DESC
 	`
 
@@ -147,8 +365,9 @@ func GetUniqueDevicesHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var nama, merek string
 		var daya, durasi float64
+		var jumlah int
 
-		if err := rows.Scan(&nama, &merek, &daya, &durasi); err != nil {
+		if err := rows.Scan(&nama, &merek, &daya, &durasi, &jumlah); err != nil {
 			continue
 		}
 
@@ -157,9 +376,9 @@ func GetUniqueDevicesHandler(w http.ResponseWriter, r *http.Request) {
 			uniqueMap[nama] = true
 
 			// Format Context String: Ini data rahasia yang bakal dikirim ke AI
-			// Contoh output: "AC Kamar (Samsung), Daya 400 Watt, Nyala 8.0 Jam/hari"
-			contextStr := fmt.Sprintf("%s (%s), Daya %.0f Watt, Nyala %.1f Jam/hari",
-				nama, merek, daya, durasi)
+			// Contoh output: "AC Kamar (Samsung), Daya 400 Watt, Jumlah 2 unit, Nyala 8 jam 30 menit/hari"
+			contextStr := fmt.Sprintf("%s (%s), Daya %.0f Watt, Jumlah %d unit, Nyala %s/hari",
+				nama, merek, daya, normalizeQuantity(jumlah), formatDurasi(durasi))
 
 			options = append(options, DeviceOption{
 				Label:   nama,      // Ini yang muncul di Layar HP User
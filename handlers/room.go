@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type RoomResponse struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	ApplianceCount int      `json:"appliance_count"`
+	IotDevices     []string `json:"iot_devices"`
+}
+
+type RoomRequest struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// RoomAssignRequest: isi salah satu dari appliance_id atau device_label.
+// room_id null / 0 berarti perangkat dilepas dari ruangan.
+type RoomAssignRequest struct {
+	RoomID      *int   `json:"room_id"`
+	ApplianceID int    `json:"appliance_id"`
+	DeviceLabel string `json:"device_label"`
+}
+
+type RoomChartData struct {
+	RoomID      *int     `json:"room_id"`
+	Name        string   `json:"name"`
+	Percentage  float64  `json:"percentage"`
+	Color       string   `json:"color"`
+	TotalPower  float64  `json:"total_power"`
+	DeviceCount int      `json:"device_count"`
+	IotDevices  []string `json:"iot_devices"`
+	Source      string   `json:"source"`
+	Confidence  string   `json:"confidence"`
+}
+
+const unassignedRoomName = "Tanpa Ruangan"
+
+// roomBelongsToUser memastikan ruangan yang dipilih memang milik user
+func roomBelongsToUser(roomID, userID int) bool {
+	var ownerID int
+	err := db.DB.QueryRow("SELECT user_id FROM ruangan WHERE id = ?", roomID).Scan(&ownerID)
+	return err == nil && ownerID == userID
+}
+
+// nullableRoomID mengubah room_id opsional dari request jadi nilai untuk kolom ruangan_id
+func nullableRoomID(roomID *int) interface{} {
+	if roomID == nil || *roomID <= 0 {
+		return nil
+	}
+	return *roomID
+}
+
+// loadIotDevicesByRoom mengambil label sensor IoT yang sudah ditempatkan di ruangan
+func loadIotDevicesByRoom(userID int) (map[int][]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT ruangan_id, device_label FROM perangkat_iot
+		WHERE user_id = ? AND ruangan_id IS NOT NULL
+		ORDER BY device_label`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make(map[int][]string)
+	for rows.Next() {
+		var roomID int
+		var label string
+		if err := rows.Scan(&roomID, &label); err != nil {
+			return nil, err
+		}
+		devices[roomID] = append(devices[roomID], label)
+	}
+	return devices, rows.Err()
+}
+
+// RoomsHandler: GET daftar ruangan, POST buat, PUT ganti nama, DELETE hapus ruangan
+func RoomsHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rows, err := db.DB.Query(`
+			SELECT r.id, r.nama_ruangan, COUNT(rp.id)
+			FROM ruangan r
+			LEFT JOIN riwayat_perangkat rp ON rp.ruangan_id = r.id AND rp.user_id = r.user_id AND rp.deleted_at IS NULL
+			WHERE r.user_id = ?
+			GROUP BY r.id, r.nama_ruangan
+			ORDER BY r.nama_ruangan`, userID)
+		if err != nil {
+			log.Printf("❌ RoomsHandler: Error querying rooms: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data ruangan"}`, http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		iotDevices, err := loadIotDevicesByRoom(userID)
+		if err != nil {
+			log.Printf("⚠️ RoomsHandler: Gagal memuat perangkat IoT: %v", err)
+		}
+
+		rooms := []RoomResponse{}
+		for rows.Next() {
+			var room RoomResponse
+			if err := rows.Scan(&room.ID, &room.Name, &room.ApplianceCount); err != nil {
+				log.Printf("❌ RoomsHandler: Error scanning room: %v", err)
+				http.Error(w, `{"error": "Gagal membaca data ruangan"}`, http.StatusInternalServerError)
+				return
+			}
+			room.IotDevices = iotDevices[room.ID]
+			if room.IotDevices == nil {
+				room.IotDevices = []string{}
+			}
+			rooms = append(rooms, room)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rooms)
+		return
+	}
+
+	var req RoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	switch r.Method {
+	case http.MethodPost:
+		if req.Name == "" {
+			http.Error(w, `{"error": "Nama ruangan tidak boleh kosong"}`, http.StatusBadRequest)
+			return
+		}
+		result, err := db.DB.Exec("INSERT INTO ruangan (user_id, nama_ruangan) VALUES (?, ?)", userID, req.Name)
+		if err != nil {
+			log.Printf("❌ RoomsHandler: Gagal membuat ruangan: %v", err)
+			http.Error(w, `{"error": "Gagal membuat ruangan. Nama mungkin sudah dipakai."}`, http.StatusConflict)
+			return
+		}
+		roomID, _ := result.LastInsertId()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Ruangan berhasil dibuat",
+			"id":      roomID,
+			"name":    req.Name,
+		})
+
+	case http.MethodPut:
+		if req.ID <= 0 || req.Name == "" {
+			http.Error(w, `{"error": "ID dan nama ruangan harus diisi"}`, http.StatusBadRequest)
+			return
+		}
+		if !roomBelongsToUser(req.ID, userID) {
+			http.Error(w, `{"error": "Ruangan tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+		if _, err := db.DB.Exec("UPDATE ruangan SET nama_ruangan = ? WHERE id = ? AND user_id = ?", req.Name, req.ID, userID); err != nil {
+			log.Printf("❌ RoomsHandler: Gagal mengganti nama ruangan: %v", err)
+			http.Error(w, `{"error": "Gagal mengganti nama ruangan. Nama mungkin sudah dipakai."}`, http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Ruangan berhasil diupdate"})
+
+	case http.MethodDelete:
+		if !roomBelongsToUser(req.ID, userID) {
+			http.Error(w, `{"error": "Ruangan tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			log.Printf("❌ RoomsHandler: Gagal memulai transaksi: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus ruangan"}`, http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Perangkat di ruangan ini tidak ikut terhapus, hanya dilepas dari ruangan
+		statements := []string{
+			"UPDATE riwayat_perangkat SET ruangan_id = NULL WHERE ruangan_id = ? AND user_id = ?",
+			"UPDATE perangkat_iot SET ruangan_id = NULL WHERE ruangan_id = ? AND user_id = ?",
+			"DELETE FROM ruangan WHERE id = ? AND user_id = ?",
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement, req.ID, userID); err != nil {
+				log.Printf("❌ RoomsHandler: Gagal menghapus ruangan: %v", err)
+				http.Error(w, `{"error": "Gagal menghapus ruangan"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("❌ RoomsHandler: Gagal commit: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus ruangan"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Ruangan berhasil dihapus"})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// AssignRoomHandler menempatkan perangkat (appliance atau sensor IoT) ke sebuah ruangan
+func AssignRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req RoomAssignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+		return
+	}
+	req.DeviceLabel = strings.TrimSpace(req.DeviceLabel)
+	if (req.ApplianceID <= 0) == (req.DeviceLabel == "") {
+		http.Error(w, `{"error": "Isi salah satu: appliance_id atau device_label"}`, http.StatusBadRequest)
+		return
+	}
+
+	roomID := nullableRoomID(req.RoomID)
+	if roomID != nil && !roomBelongsToUser(roomID.(int), userID) {
+		http.Error(w, `{"error": "Ruangan tidak ditemukan"}`, http.StatusNotFound)
+		return
+	}
+
+	var result sql.Result
+	if req.ApplianceID > 0 {
+		result, err = db.DB.Exec("UPDATE riwayat_perangkat SET ruangan_id = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+			roomID, req.ApplianceID, userID)
+	} else {
+		result, err = db.DB.Exec(`
+			INSERT INTO perangkat_iot (user_id, device_label, ruangan_id) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE ruangan_id = VALUES(ruangan_id)`,
+			userID, req.DeviceLabel, roomID)
+	}
+	if err != nil {
+		log.Printf("❌ AssignRoomHandler: Gagal menempatkan perangkat: %v", err)
+		http.Error(w, `{"error": "Gagal menempatkan perangkat ke ruangan"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if req.ApplianceID > 0 {
+		// RowsAffected 0 bisa berarti ruangannya sama; cek dulu kepemilikan perangkat
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			var exists int
+			if err := db.DB.QueryRow("SELECT COUNT(*) FROM riwayat_perangkat WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+				req.ApplianceID, userID).Scan(&exists); err != nil || exists == 0 {
+				http.Error(w, `{"error": "Perangkat tidak ditemukan"}`, http.StatusNotFound)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Perangkat berhasil ditempatkan",
+		"room_id": roomID,
+	})
+}
+
+// roomColor: warna tetap per ruangan (berdasarkan ruangan_id, bukan urutan peringkat) dari palet kategori;
+// perangkat tanpa ruangan selalu abu-abu
+func roomColor(roomID *int) string {
+	if roomID == nil {
+		return uncategorizedColor
+	}
+	return categoryPalette[(*roomID-1+len(categoryPalette))%len(categoryPalette)]
+}
+
+// GetRoomStatisticsHandler: pembagian konsumsi per ruangan (pasangan GetCategoryStatisticsHandler)
+func GetRoomStatisticsHandler(w http.ResponseWriter, r *http.Request) {
+	session, errSession := Store.Get(r, "elektronik_rumah_session")
+	if errSession != nil {
+		log.Printf("❌ GetRoomStatisticsHandler: Error getting session: %v", errSession)
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		log.Println("❌ GetRoomStatisticsHandler: Unauthorized, user_id not found in session")
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	records, errRecords := loadApplianceRecords(userID)
+	if errRecords != nil {
+		log.Printf("❌ GetRoomStatisticsHandler: Error loading records: %v", errRecords)
+		http.Error(w, `{"error": "Gagal mengambil data statistik ruangan"}`, http.StatusInternalServerError)
+		return
+	}
+	profile := userBillingProfile(userID)
+
+	iotDevices, err := loadIotDevicesByRoom(userID)
+	if err != nil {
+		log.Printf("⚠️ GetRoomStatisticsHandler: Gagal memuat perangkat IoT: %v", err)
+	}
+
+	// Per ruangan dari gabungan perkiraan dan data terukur (termasuk sensor ruangan yang tidak ditautkan ke perangkat);
+	// energi meter utama yang tidak bisa dibagi masuk Tanpa Ruangan
+	type roomTotal struct {
+		stat    RoomChartData
+		devices map[string]bool
+		usageTotal
+	}
+	totals := make(map[int]*roomTotal)
+	from, to := statisticsRange(records, time.Local)
+	forEachUsageHour(records, loadMeasuredUsage(userID, from, to, time.Local), profile, from, to, func(hour usageHour) {
+		for key, kwh := range hour.Kwh {
+			rec := hour.Records[key]
+			roomKey := 0
+			if rec.RoomID != nil {
+				roomKey = *rec.RoomID
+			}
+			total, exists := totals[roomKey]
+			if !exists {
+				total = &roomTotal{stat: RoomChartData{RoomID: rec.RoomID, Name: unassignedRoomName}, devices: make(map[string]bool)}
+				if rec.RoomID != nil {
+					total.stat.Name = rec.RoomName
+				}
+				totals[roomKey] = total
+			}
+			if key != unattributedKey {
+				total.devices[key] = true
+			}
+			total.add(kwh, hour.Rate, hour.Measured[key])
+		}
+	})
+
+	roomStats := []RoomChartData{}
+	var totalOverallPowerKWh float64
+	for roomKey, total := range totals {
+		if total.Kwh <= 0 {
+			continue
+		}
+		stat := total.stat
+		stat.TotalPower = total.Kwh
+		stat.DeviceCount = len(total.devices)
+		stat.Source, stat.Confidence = total.label()
+		stat.IotDevices = []string{}
+		if labels := iotDevices[roomKey]; roomKey != 0 && labels != nil {
+			stat.IotDevices = labels
+		}
+		roomStats = append(roomStats, stat)
+		totalOverallPowerKWh += stat.TotalPower
+	}
+	sort.Slice(roomStats, func(i, j int) bool {
+		if roomStats[i].TotalPower != roomStats[j].TotalPower {
+			return roomStats[i].TotalPower > roomStats[j].TotalPower
+		}
+		return roomStats[i].Name < roomStats[j].Name
+	})
+
+	for i := range roomStats {
+		if totalOverallPowerKWh > 0 {
+			roomStats[i].Percentage = (roomStats[i].TotalPower / totalOverallPowerKWh) * 100
+		}
+		roomStats[i].Color = roomColor(roomStats[i].RoomID)
+	}
+
+	log.Printf("✅ Room statistics response: %+v", roomStats)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roomStats)
+}
@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"EnerTrack-BE/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+var weekdayLabels = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// Request body untuk simpan jadwal satu perangkat
+type ScheduleRequest struct {
+	ID       int                  `json:"id"`
+	Schedule []models.UsageWindow `json:"schedule"`
+}
+
+// scheduledLoad adalah satu perangkat (daya total semua unit) beserta jadwalnya
+type scheduledLoad struct {
+	ID       int
+	Name     string
+	Watts    float64
+	Schedule []models.UsageWindow
+}
+
+type PeakLoadResponse struct {
+	PeakWatts   float64  `json:"peak_watts"`
+	PeakDay     string   `json:"peak_day"`
+	PeakTime    string   `json:"peak_time"`
+	Devices     []string `json:"devices"`
+	Unscheduled []string `json:"unscheduled"`
+}
+
+// parseClock mengubah "HH:MM" (atau "HH:MM:SS" dari kolom TIME) jadi menit sejak 00:00.
+// "24:00" diperbolehkan sebagai jam selesai.
+func parseClock(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("format jam tidak valid: %q", value)
+	}
+	hour, errHour := strconv.Atoi(parts[0])
+	minute, errMinute := strconv.Atoi(parts[1])
+	if errHour != nil || errMinute != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("format jam tidak valid: %q", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// windowBounds mengembalikan menit mulai dan selesai relatif ke awal hari Weekday.
+// Untuk rentang lewat tengah malam, end bisa lebih dari 1440.
+func windowBounds(window models.UsageWindow) (int, int, error) {
+	if window.Weekday < 0 || window.Weekday > 6 {
+		return 0, 0, fmt.Errorf("weekday harus 0 (Minggu) sampai 6 (Sabtu)")
+	}
+	start, err := parseClock(window.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(window.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("jam mulai dan selesai tidak boleh sama (%s)", window.Start)
+	}
+	if end < start {
+		end += minutesPerDay
+	}
+	return start, end, nil
+}
+
+func windowMinutes(window models.UsageWindow) int {
+	start, end, err := windowBounds(window)
+	if err != nil {
+		return 0
+	}
+	return end - start
+}
+
+// validateSchedule memastikan setiap rentang valid dan tidak saling tumpang tindih
+func validateSchedule(schedule []models.UsageWindow) error {
+	used := make([]bool, minutesPerWeek)
+	for _, window := range schedule {
+		start, end, err := windowBounds(window)
+		if err != nil {
+			return err
+		}
+		base := window.Weekday * minutesPerDay
+		for m := start; m < end; m++ {
+			slot := (base + m) % minutesPerWeek
+			if used[slot] {
+				return fmt.Errorf("jadwal %s %s-%s tumpang tindih dengan rentang lain",
+					weekdayLabels[window.Weekday], window.Start, window.End)
+			}
+			used[slot] = true
+		}
+	}
+	return nil
+}
+
+// dailyUsageHours: jam nyala pada hari tertentu. Tanpa jadwal, pakai durasi harian biasa.
+func dailyUsageHours(schedule []models.UsageWindow, weekday time.Weekday, fallbackHours float64) float64 {
+	if len(schedule) == 0 {
+		return fallbackHours
+	}
+	minutes := 0
+	for _, window := range schedule {
+		if window.Weekday == int(weekday) {
+			minutes += windowMinutes(window)
+		}
+	}
+	return float64(minutes) / 60.0
+}
+
+// averageDailyHours: rata-rata jam nyala per hari dalam seminggu
+func averageDailyHours(schedule []models.UsageWindow, fallbackHours float64) float64 {
+	if len(schedule) == 0 {
+		return fallbackHours
+	}
+	minutes := 0
+	for _, window := range schedule {
+		minutes += windowMinutes(window)
+	}
+	return float64(minutes) / 60.0 / 7.0
+}
+
+// describeSchedule menghasilkan teks ringkas, contoh: "Mon 22:00-06:00, Sat 08:00-22:00"
+func describeSchedule(schedule []models.UsageWindow) string {
+	parts := make([]string, 0, len(schedule))
+	for _, window := range schedule {
+		if window.Weekday < 0 || window.Weekday > 6 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %s-%s", weekdayLabels[window.Weekday], window.Start, window.End))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// saveSchedule mengganti jadwal satu baris riwayat (jadwal kosong = kembali ke durasi harian)
+func saveSchedule(execer sqlExecer, riwayatID int, schedule []models.UsageWindow) error {
+	if _, err := execer.Exec(`DELETE FROM jadwal_perangkat WHERE riwayat_id = ?`, riwayatID); err != nil {
+		return err
+	}
+	for _, window := range schedule {
+		if _, err := execer.Exec(`INSERT INTO jadwal_perangkat (riwayat_id, hari, jam_mulai, jam_selesai) VALUES (?, ?, ?, ?)`,
+			riwayatID, window.Weekday, window.Start, window.End); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestSchedulesByIdentity: jadwal perangkat di submit terakhir per identityKey (nama + merek),
+// supaya jadwal ikut terbawa saat user submit ulang tanpa mengisi jadwal lagi
+func latestSchedulesByIdentity(userID int) (map[string][]models.UsageWindow, error) {
+	records, err := latestSubmissionRecords(userID)
+	if err != nil {
+		return nil, err
+	}
+	schedules := make(map[string][]models.UsageWindow)
+	for _, rec := range records {
+		if _, exists := schedules[rec.identityKey()]; !exists && len(rec.Schedule) > 0 {
+			schedules[rec.identityKey()] = rec.Schedule
+		}
+	}
+	return schedules, nil
+}
+
+// loadUserSchedules mengambil semua jadwal milik user, dikelompokkan per riwayat_id
+func loadUserSchedules(userID int) (map[int][]models.UsageWindow, error) {
+	rows, err := db.DB.Query(`
+		SELECT j.riwayat_id, j.hari, TIME_FORMAT(j.jam_mulai, '%H:%i'), TIME_FORMAT(j.jam_selesai, '%H:%i')
+		FROM jadwal_perangkat j
+		JOIN riwayat_perangkat rp ON rp.id = j.riwayat_id
+		WHERE rp.user_id = ?
+		ORDER BY j.riwayat_id, j.hari, j.jam_mulai`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make(map[int][]models.UsageWindow)
+	for rows.Next() {
+		var riwayatID int
+		var window models.UsageWindow
+		if err := rows.Scan(&riwayatID, &window.Weekday, &window.Start, &window.End); err != nil {
+			return nil, err
+		}
+		schedules[riwayatID] = append(schedules[riwayatID], window)
+	}
+	return schedules, rows.Err()
+}
+
+// estimatePeakLoad mencari menit dengan total beban terbesar dalam seminggu
+func estimatePeakLoad(loads []scheduledLoad) PeakLoadResponse {
+	loadByMinute := make([]float64, minutesPerWeek)
+	response := PeakLoadResponse{Devices: []string{}, Unscheduled: []string{}}
+
+	for _, load := range loads {
+		if len(load.Schedule) == 0 {
+			response.Unscheduled = append(response.Unscheduled, load.Name)
+			continue
+		}
+		for _, window := range load.Schedule {
+			start, end, err := windowBounds(window)
+			if err != nil {
+				continue
+			}
+			base := window.Weekday * minutesPerDay
+			for m := start; m < end; m++ {
+				loadByMinute[(base+m)%minutesPerWeek] += load.Watts
+			}
+		}
+	}
+
+	peakMinute := 0
+	for m, watts := range loadByMinute {
+		if watts > loadByMinute[peakMinute] {
+			peakMinute = m
+		}
+	}
+	response.PeakWatts = loadByMinute[peakMinute]
+	if response.PeakWatts == 0 {
+		return response
+	}
+
+	response.PeakDay = weekdayLabels[peakMinute/minutesPerDay]
+	minuteOfDay := peakMinute % minutesPerDay
+	response.PeakTime = fmt.Sprintf("%02d:%02d", minuteOfDay/60, minuteOfDay%60)
+	for _, load := range loads {
+		if isActiveAt(load.Schedule, peakMinute) {
+			response.Devices = append(response.Devices, load.Name)
+		}
+	}
+	return response
+}
+
+// isActiveAt mengecek apakah jadwal mencakup menit ke-N dalam seminggu (0 = Minggu 00:00)
+func isActiveAt(schedule []models.UsageWindow, minuteOfWeek int) bool {
+	for _, window := range schedule {
+		start, end, err := windowBounds(window)
+		if err != nil {
+			continue
+		}
+		base := window.Weekday * minutesPerDay
+		offset := (minuteOfWeek - base + minutesPerWeek) % minutesPerWeek
+		if offset >= start && offset < end {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplianceScheduleHandler: GET ?id= untuk lihat jadwal, PUT untuk mengganti jadwal
+func ApplianceScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		applianceID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil || applianceID <= 0 {
+			http.Error(w, `{"error": "Parameter id tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+
+		var durasi float64
+		err = db.DB.QueryRow(`SELECT durasi FROM riwayat_perangkat WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, applianceID, userID).Scan(&durasi)
+		if err == sql.ErrNoRows {
+			http.Error(w, `{"error": "Perangkat tidak ditemukan"}`, http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Error checking appliance: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		schedules, err := loadUserSchedules(userID)
+		if err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Error loading schedules: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil jadwal"}`, http.StatusInternalServerError)
+			return
+		}
+		schedule := schedules[applianceID]
+		if schedule == nil {
+			schedule = []models.UsageWindow{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":                  applianceID,
+			"schedule":            schedule,
+			"average_daily_hours": averageDailyHours(schedule, durasi),
+		})
+
+	case http.MethodPut:
+		var req ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if err := validateSchedule(req.Schedule); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		var daya, durasi float64
+		var jumlah int
+		var besarListrik string
+		err := db.DB.QueryRow(`
+			SELECT daya, durasi, COALESCE(jumlah, 1), COALESCE(besar_listrik, '')
+			FROM riwayat_perangkat WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, req.ID, userID).Scan(&daya, &durasi, &jumlah, &besarListrik)
+		if err == sql.ErrNoRows {
+			http.Error(w, `{"error": "Perangkat tidak ditemukan"}`, http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Error checking appliance: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		// Proyeksi biaya ikut jadwal baru (jadwal kosong = kembali ke durasi harian)
+		avgHours := averageDailyHours(req.Schedule, durasi)
+		dailyEnergyKWh := daya * avgHours * float64(normalizeQuantity(jumlah)) / 1000.0
+		weeklyUsage := dailyEnergyKWh * 7
+		monthlyUsage := dailyEnergyKWh * 30
+		monthlyCost := monthlyUsage * tariffPerKwh(besarListrik, userSubsidized(userID))
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Gagal memulai transaksi: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan jadwal"}`, http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := saveSchedule(tx, req.ID, req.Schedule); err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Gagal menyimpan jadwal: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan jadwal"}`, http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(`
+			UPDATE riwayat_perangkat SET Weekly_Usage = ?, Monthly_Usage = ?, Monthly_cost = ?
+			WHERE id = ? AND user_id = ?`, weeklyUsage, monthlyUsage, monthlyCost, req.ID, userID); err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Gagal update proyeksi: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan jadwal"}`, http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("❌ ApplianceScheduleHandler: Gagal commit: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan jadwal"}`, http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("✅ Jadwal perangkat %d disimpan (%d rentang)", req.ID, len(req.Schedule))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":             "Jadwal berhasil disimpan",
+			"id":                  req.ID,
+			"average_daily_hours": avgHours,
+			"weekly_usage":        weeklyUsage,
+			"monthly_usage":       monthlyUsage,
+			"monthly_cost":        monthlyCost,
+		})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// PeakLoadHandler mengestimasi beban puncak mingguan dari jadwal perangkat submit terakhir
+func PeakLoadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, nama_perangkat, daya, COALESCE(jumlah, 1)
+		FROM riwayat_perangkat
+		WHERE user_id = ? AND deleted_at IS NULL AND id_submit = (
+			SELECT id_submit FROM riwayat_perangkat
+			WHERE user_id = ? AND deleted_at IS NULL ORDER BY tanggal_input DESC, id DESC LIMIT 1
+		)`, userID, userID)
+	if err != nil {
+		log.Printf("❌ PeakLoadHandler: Error querying appliances: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	schedules, err := loadUserSchedules(userID)
+	if err != nil {
+		log.Printf("❌ PeakLoadHandler: Error loading schedules: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil jadwal"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var loads []scheduledLoad
+	for rows.Next() {
+		var load scheduledLoad
+		var daya float64
+		var jumlah int
+		if err := rows.Scan(&load.ID, &load.Name, &daya, &jumlah); err != nil {
+			log.Printf("❌ PeakLoadHandler: Error scanning row: %v", err)
+			continue
+		}
+		load.Watts = daya * float64(normalizeQuantity(jumlah))
+		load.Schedule = schedules[load.ID]
+		loads = append(loads, load)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(estimatePeakLoad(loads))
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultSeriesDays = 30
+	maxSeriesBuckets  = 1000
+)
+
+// seriesGranularities: granularitas yang didukung /statistics/series
+var seriesGranularities = map[string]bool{"hour": true, "day": true, "week": true, "month": true, "year": true}
+
+type SeriesPoint struct {
+	Start       string  `json:"start"`
+	Label       string  `json:"label"`
+	Kwh         float64 `json:"kwh"`
+	Rp          float64 `json:"rp"`
+	MeasuredKwh float64 `json:"measured_kwh"`
+	Source      string  `json:"source"`
+	Confidence  string  `json:"confidence"`
+	// coverage: jumlah jam dan jam terukur, supaya bucket bisa digabung lagi (mis. hari -> minggu)
+	coverage seriesCoverage
+}
+
+type SeriesResponse struct {
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Granularity string        `json:"granularity"`
+	Timezone    string        `json:"timezone"`
+	TotalKwh    float64       `json:"total_kwh"`
+	TotalRp     float64       `json:"total_rp"`
+	Points      []SeriesPoint `json:"points"`
+}
+
+// bucketStart: awal bucket yang memuat t (minggu dimulai hari Senin)
+func bucketStart(t time.Time, granularity string) time.Time {
+	loc := t.Location()
+	switch granularity {
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// nextBucket pakai time.Date (bukan Add) supaya tetap benar di zona waktu dengan DST
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case "hour":
+		return time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+1, 0, 0, 0, start.Location())
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	case "year":
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func bucketLabel(start time.Time, granularity string) string {
+	switch granularity {
+	case "hour":
+		return start.Format("2006-01-02 15:00")
+	case "week":
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return start.Format("2006-01")
+	case "year":
+		return start.Format("2006")
+	default:
+		return start.Format("2006-01-02")
+	}
+}
+
+// unattributedKey: kunci untuk energi meter utama yang tidak bisa dibagi ke perangkat
+// (misalnya jam di mana semua perangkat diperkirakan mati)
+const unattributedKey = ""
+
+// usageHour: pemakaian satu jam per perangkat (identityKey) setelah data terukur digabung.
+// Rate = Rp/kWh termasuk PPJ. Measured[key] true kalau kWh perangkat itu berasal dari sensor.
+type usageHour struct {
+	At       time.Time
+	Rate     float64
+	Records  map[string]applianceRecord
+	Kwh      map[string]float64
+	Measured map[string]bool
+}
+
+// forEachUsageHour menggabungkan perkiraan dan data terukur per jam pada [from, to) lalu memanggil fn tiap jam.
+// Sensor yang ditautkan menggantikan perkiraan perangkatnya; sensor ruangan tanpa tautan masuk sebagai
+// perangkat semu. Kalau jam itu ada data meter utama, sisanya (meter utama dikurangi kedua jenis sensor itu) dibagi ke perangkat lain sesuai porsi perkiraannya, jadi total jam
+// itu mengikuti meter. Map di usageHour dipakai ulang antar jam; fn tidak boleh menyimpannya.
+func forEachUsageHour(records []applianceRecord, measured measuredUsage, profile BillingProfile, from, to time.Time, fn func(hour usageHour)) {
+	timeline := buildInventoryTimeline(records)
+	ppj := 1 + profile.Region.PPJPercent/100
+	hour := usageHour{
+		Records:  make(map[string]applianceRecord),
+		Kwh:      make(map[string]float64),
+		Measured: make(map[string]bool),
+	}
+	for day := bucketStart(from, "day"); day.Before(to); day = day.AddDate(0, 0, 1) {
+		batch, _ := timeline.activeOn(day, false)
+		besarListrik := ""
+		if len(batch.Records) > 0 {
+			besarListrik = batch.Records[0].BesarListrik
+		}
+		hour.Rate = tariffPerKwhAt(besarListrik, profile.Subsidized, day) * ppj
+
+		// Perkiraan per jam per perangkat (identityKey), supaya bisa diganti data sensor yang ditautkan
+		clear(hour.Records)
+		estimated := make(map[string][24]float64)
+		for _, rec := range batch.Records {
+			hourly := estimated[rec.identityKey()]
+			for h, kwh := range rec.hourlyKWhOn(day) {
+				hourly[h] += kwh
+			}
+			estimated[rec.identityKey()] = hourly
+			hour.Records[rec.identityKey()] = rec
+		}
+
+		for h := 0; h < 24; h++ {
+			at := time.Date(day.Year(), day.Month(), day.Day(), h, 0, 0, 0, day.Location())
+			if !at.Before(to) {
+				break
+			}
+			if at.Before(from) {
+				continue
+			}
+			hour.At = at
+			clear(hour.Kwh)
+			clear(hour.Measured)
+
+			linkedKwh, otherKwh := 0.0, 0.0
+			for key, hourly := range estimated {
+				if kwh, found := measured.Linked[key][at.Unix()]; found {
+					hour.Kwh[key], hour.Measured[key] = kwh, true
+					linkedKwh += kwh
+					continue
+				}
+				hour.Kwh[key] = hourly[h]
+				otherKwh += hourly[h]
+			}
+			for key, sensor := range measured.Placed {
+				if kwh, found := sensor.Hours[at.Unix()]; found {
+					hour.Kwh[key], hour.Measured[key] = kwh, true
+					hour.Records[key] = sensor.Record
+					linkedKwh += kwh
+				}
+			}
+			if mainKwh, found := measured.Main[at.Unix()]; found {
+				rest := max(mainKwh-linkedKwh, 0)
+				for key := range estimated {
+					if hour.Measured[key] {
+						continue
+					}
+					if otherKwh > 0 {
+						hour.Kwh[key] *= rest / otherKwh
+					}
+					hour.Measured[key] = true
+				}
+				if otherKwh == 0 && rest > 0 {
+					hour.Kwh[unattributedKey], hour.Measured[unattributedKey] = rest, true
+				}
+			}
+			fn(hour)
+		}
+	}
+}
+
+// usageTotal: kWh, Rp, dan porsi terukur satu kelompok (perangkat, kategori, ruangan, atau periode)
+type usageTotal struct {
+	Kwh, Rp, MeasuredKwh float64
+	coverage             seriesCoverage
+}
+
+// add menambahkan pemakaian satu jam; tiap panggilan dihitung satu jam untuk coverage
+func (t *usageTotal) add(kwh, rate float64, measured bool) {
+	t.Kwh += kwh
+	t.Rp += kwh * rate
+	t.coverage.Hours++
+	if measured {
+		t.MeasuredKwh += kwh
+		t.coverage.MeasuredHours++
+	}
+}
+
+func (t *usageTotal) merge(other usageTotal) {
+	t.Kwh += other.Kwh
+	t.Rp += other.Rp
+	t.MeasuredKwh += other.MeasuredKwh
+	t.coverage.Hours += other.coverage.Hours
+	t.coverage.MeasuredHours += other.coverage.MeasuredHours
+}
+
+// addHour menambahkan pemakaian semua perangkat dalam satu jam sebagai satu jam coverage
+func (t *usageTotal) addHour(hour usageHour) {
+	covered := false
+	for key, kwh := range hour.Kwh {
+		t.Kwh += kwh
+		t.Rp += kwh * hour.Rate
+		if hour.Measured[key] {
+			t.MeasuredKwh += kwh
+			covered = true
+		}
+	}
+	t.coverage.Hours++
+	if covered {
+		t.coverage.MeasuredHours++
+	}
+}
+
+func (t usageTotal) label() (string, string) {
+	return t.coverage.label(t.Kwh, t.MeasuredKwh)
+}
+
+// buildUsageSeries menghitung deret kWh dan Rp untuk rentang [from, to) dalam bucket granularity,
+// dengan data terukur digabung lewat forEachUsageHour. Jam tanpa sensor memakai perkiraan.
+// Bucket kosong tetap muncul dengan nilai 0. Rp = kWh x tarif x (1 + PPJ); biaya tetap bulanan
+// (admin, rekening minimum) tidak dibagi ke bucket.
+func buildUsageSeries(records []applianceRecord, measured measuredUsage, profile BillingProfile, from, to time.Time, granularity string) []SeriesPoint {
+	var points []SeriesPoint
+	var totals []usageTotal
+	index := make(map[int64]int)
+	for start := bucketStart(from, granularity); start.Before(to); start = nextBucket(start, granularity) {
+		index[start.Unix()] = len(points)
+		points = append(points, SeriesPoint{Start: start.Format(time.RFC3339), Label: bucketLabel(start, granularity)})
+		totals = append(totals, usageTotal{})
+	}
+
+	forEachUsageHour(records, measured, profile, from, to, func(hour usageHour) {
+		if i, ok := index[bucketStart(hour.At, granularity).Unix()]; ok {
+			totals[i].addHour(hour)
+		}
+	})
+
+	for i, total := range totals {
+		points[i].Source, points[i].Confidence = total.label()
+		points[i].Kwh = roundTo(total.Kwh, 3)
+		points[i].MeasuredKwh = roundTo(total.MeasuredKwh, 3)
+		points[i].Rp = roundTo(total.Rp, 0)
+		points[i].coverage = total.coverage
+	}
+	return points
+}
+
+// seriesCoverage: berapa jam dalam satu bucket yang punya data sensor
+type seriesCoverage struct {
+	Hours, MeasuredHours int
+}
+
+// label menentukan sumber (measured / estimated / mixed) dan tingkat keyakinan dari porsi kWh terukur
+func (c seriesCoverage) label(kwh, measuredKwh float64) (string, string) {
+	source := "mixed"
+	switch c.MeasuredHours {
+	case 0:
+		source = "estimated"
+	case c.Hours:
+		source = "measured"
+	}
+
+	share := 0.0
+	if kwh > 0 {
+		share = measuredKwh / kwh
+	} else if c.Hours > 0 {
+		share = float64(c.MeasuredHours) / float64(c.Hours)
+	}
+	switch {
+	case share >= 0.9:
+		return source, "high"
+	case share >= 0.5:
+		return source, "medium"
+	default:
+		return source, "low"
+	}
+}
+
+// parseSeriesRange membaca from/to (YYYY-MM-DD, inklusif) di zona waktu loc.
+// Default 30 hari terakhir; rentang tidak melewati hari ini.
+func parseSeriesRange(fromRaw, toRaw string, loc *time.Location) (time.Time, time.Time, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	to := today
+	if toRaw != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", toRaw, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("format to tidak valid, gunakan YYYY-MM-DD")
+		}
+		to = parsed
+	}
+	if to.After(today) {
+		to = today
+	}
+
+	from := to.AddDate(0, 0, -(defaultSeriesDays - 1))
+	if fromRaw != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", fromRaw, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("format from tidak valid, gunakan YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from tidak boleh setelah to")
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// countBuckets menghitung jumlah bucket tanpa membuat deretnya (untuk batas ukuran respons)
+func countBuckets(from, to time.Time, granularity string) int {
+	count := 0
+	for start := bucketStart(from, granularity); start.Before(to) && count <= maxSeriesBuckets; start = nextBucket(start, granularity) {
+		count++
+	}
+	return count
+}
+
+// GetUsageSeriesHandler: /statistics/series?from=&to=&granularity=hour|day|week|month|year&tz=
+func GetUsageSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	granularity := query.Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+	if !seriesGranularities[granularity] {
+		http.Error(w, `{"error": "granularity harus hour, day, week, month, atau year"}`, http.StatusBadRequest)
+		return
+	}
+
+	loc := time.Local
+	if tz := query.Get("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, `{"error": "Zona waktu tidak dikenali"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	from, to, err := parseSeriesRange(query.Get("from"), query.Get("to"), loc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if countBuckets(from, to, granularity) > maxSeriesBuckets {
+		http.Error(w, fmt.Sprintf(`{"error": "Rentang terlalu panjang untuk granularity %s (maksimal %d titik)"}`, granularity, maxSeriesBuckets), http.StatusBadRequest)
+		return
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ GetUsageSeriesHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	profile := userBillingProfile(userID)
+
+	response := SeriesResponse{
+		From:        from.Format("2006-01-02"),
+		To:          to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Granularity: granularity,
+		Timezone:    loc.String(),
+		Points:      buildUsageSeries(records, loadMeasuredUsage(userID, from, to, loc), profile, from, to, granularity),
+	}
+	for _, point := range response.Points {
+		response.TotalKwh += point.Kwh
+		response.TotalRp += point.Rp
+	}
+	response.TotalKwh = roundTo(response.TotalKwh, 3)
+	response.TotalRp = roundTo(response.TotalRp, 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
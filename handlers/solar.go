@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// solarPerformanceRatio: rugi-rugi inverter, kabel, suhu dan debu pada sistem PLTS atap
+	solarPerformanceRatio = 0.8
+	defaultSolarCity      = "Jakarta"
+	defaultCostPerKWp     = 15000000
+	// defaultExportRate: kWh ekspor dihitung penuh (Permen ESDM 26/2021).
+	// Permen ESDM 2/2024 tidak lagi memperhitungkan ekspor untuk pelanggan baru, kirim export_rate=0.
+	defaultExportRate = 1.0
+	// solarCreditMonths: kelebihan kredit ekspor diakumulasi maksimal 6 bulan (Januari-Juni, Juli-Desember)
+	solarCreditMonths = 6
+)
+
+// orientationFactors: faktor produksi relatif terhadap panel menghadap utara (terbaik untuk lokasi di selatan khatulistiwa)
+var orientationFactors = map[string]float64{
+	"utara":   1.0,
+	"datar":   0.97,
+	"timur":   0.93,
+	"barat":   0.93,
+	"selatan": 0.88,
+}
+
+type SolarMonth struct {
+	Month           string  `json:"month"`
+	ProductionKwh   float64 `json:"production_kwh"`
+	ConsumptionKwh  float64 `json:"consumption_kwh"`
+	SelfConsumedKwh float64 `json:"self_consumed_kwh"`
+	ExportKwh       float64 `json:"export_kwh"`
+	ImportKwh       float64 `json:"import_kwh"`
+	CreditUsedKwh   float64 `json:"credit_used_kwh"`
+	CreditLeftKwh   float64 `json:"credit_left_kwh"`
+	BilledKwh       float64 `json:"billed_kwh"`
+	BillBefore      float64 `json:"bill_before"`
+	BillAfter       float64 `json:"bill_after"`
+	Savings         float64 `json:"savings"`
+}
+
+type SolarSimulation struct {
+	City               string       `json:"city"`
+	KWp                float64      `json:"kwp"`
+	Orientation        string       `json:"orientation"`
+	OrientationFactor  float64      `json:"orientation_factor"`
+	ExportRate         float64      `json:"export_rate"`
+	ProfileSource      string       `json:"profile_source"`
+	HourlyProfileKwh   [24]float64  `json:"hourly_profile_kwh"`
+	Months             []SolarMonth `json:"months"`
+	AnnualProduction   float64      `json:"annual_production_kwh"`
+	SelfConsumptionPct float64      `json:"self_consumption_percent"`
+	SolarFractionPct   float64      `json:"solar_fraction_percent"`
+	AnnualSavings      float64      `json:"annual_savings"`
+	SystemCost         float64      `json:"system_cost"`
+	PaybackYears       *float64     `json:"payback_years"`
+	Warnings           []string     `json:"warnings"`
+}
+
+// loadIrradiance mengambil profil irradiasi kota: [bulan 0-11][jam 0-23] dalam Wh/m2
+func loadIrradiance(city string) ([12][24]float64, bool, error) {
+	var profile [12][24]float64
+	rows, err := db.DB.Query("SELECT bulan, jam, wh_per_m2 FROM irradiasi_kota WHERE kota = ?", city)
+	if err != nil {
+		return profile, false, err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var month, hour int
+		var wh float64
+		if err := rows.Scan(&month, &hour, &wh); err != nil {
+			return profile, false, err
+		}
+		if month < 1 || month > 12 || hour < 0 || hour > 23 {
+			continue
+		}
+		profile[month-1][hour] = wh
+		found = true
+	}
+	return profile, found, rows.Err()
+}
+
+func solarCities() ([]string, error) {
+	rows, err := db.DB.Query("SELECT DISTINCT kota FROM irradiasi_kota ORDER BY kota")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cities := []string{}
+	for rows.Next() {
+		var city string
+		if err := rows.Scan(&city); err != nil {
+			return nil, err
+		}
+		cities = append(cities, city)
+	}
+	return cities, rows.Err()
+}
+
+// measuredHourlyProfile: rata-rata kWh per jam dari meter utama sejak tanggal tertentu.
+// Jam dan tanggal dihitung di zona waktu since (energy_logs tersimpan dalam UTC); colokan pintar
+// yang ditautkan ke perangkat tidak ikut supaya tidak terhitung dua kali.
+func measuredHourlyProfile(userID int, since time.Time) ([24]float64, bool) {
+	var profile [24]float64
+	energy, err := houseEnergy(userID, since, time.Now(), true)
+	if err != nil {
+		log.Printf("❌ measuredHourlyProfile: Error querying energy_logs: %v", err)
+		return profile, false
+	}
+
+	days := make(map[string]bool)
+	for _, e := range energy {
+		at := e.First.In(since.Location())
+		days[at.Format("2006-01-02")] = true
+		profile[at.Hour()] += e.Kwh
+	}
+	if len(days) == 0 {
+		return profile, false
+	}
+	for hour := range profile {
+		profile[hour] /= float64(len(days))
+	}
+	return profile, true
+}
+
+// scheduledHourlyProfile: rata-rata kWh per jam dari jadwal perangkat; yang tanpa jadwal disebar rata 24 jam
+func scheduledHourlyProfile(records []applianceRecord) [24]float64 {
+	var profile [24]float64
+	for _, rec := range records {
+		kw := rec.Power * float64(rec.Quantity) / 1000.0
+		if len(rec.Schedule) == 0 {
+			for hour := range profile {
+				profile[hour] += kw * rec.Duration / 24
+			}
+			continue
+		}
+		for _, window := range rec.Schedule {
+			start, end, err := windowBounds(window)
+			if err != nil {
+				continue
+			}
+			// Jadwal mingguan, jadi dibagi 7 untuk rata-rata harian
+			for minute := start; minute < end; minute++ {
+				profile[(minute%minutesPerDay)/60] += kw / 60 / 7
+			}
+		}
+	}
+	return profile
+}
+
+// simulateSolar menjalankan simulasi per jam selama 12 bulan mulai Januari tahun berjalan.
+// Ekspor mengurangi kWh impor bulan yang sama; sisanya jadi kredit yang hangus tiap akhir periode 6 bulan.
+func simulateSolar(sim *SolarSimulation, irradiance [12][24]float64, besarListrik string, profile BillingProfile, year int) {
+	// Produksi per jam (kWh) = kWp x irradiasi (kWh/m2, kondisi uji 1 kW/m2) x faktor arah x performance ratio
+	factor := sim.KWp * sim.OrientationFactor * solarPerformanceRatio / 1000
+
+	credit := 0.0
+	totalSelf, totalConsumption := 0.0, 0.0
+	for month := 0; month < 12; month++ {
+		start := time.Date(year, time.Month(month+1), 1, 0, 0, 0, 0, time.Local)
+		days := float64(start.AddDate(0, 1, -1).Day())
+		if month%solarCreditMonths == 0 {
+			credit = 0
+		}
+
+		result := SolarMonth{Month: start.Format("2006-01")}
+		for hour := 0; hour < 24; hour++ {
+			production := irradiance[month][hour] * factor
+			consumption := sim.HourlyProfileKwh[hour]
+			self := min(production, consumption)
+			result.ProductionKwh += production * days
+			result.ConsumptionKwh += consumption * days
+			result.SelfConsumedKwh += self * days
+			result.ExportKwh += (production - self) * days
+			result.ImportKwh += (consumption - self) * days
+		}
+
+		// Ekspor yang diperhitungkan mengurangi impor; kelebihannya masuk kredit bulan berikutnya
+		netImport := result.ImportKwh - result.ExportKwh*sim.ExportRate
+		if netImport < 0 {
+			credit += -netImport
+			netImport = 0
+		}
+		result.CreditUsedKwh = min(credit, netImport)
+		if netImport > 0 {
+			credit -= result.CreditUsedKwh
+			netImport -= result.CreditUsedKwh
+		}
+		result.CreditLeftKwh = roundTo(credit, 2)
+		result.BilledKwh = roundTo(netImport, 2)
+
+		// Rekening minimum tetap berlaku, jadi penghematan bisa lebih kecil dari nilai kWh yang diproduksi
+		before := calculateBill(result.ConsumptionKwh, besarListrik, profile, start)
+		after := calculateBill(netImport, besarListrik, profile, start)
+		result.BillBefore = before.Total
+		result.BillAfter = after.Total
+		result.Savings = roundTo(before.Total-after.Total, 0)
+
+		sim.AnnualProduction += result.ProductionKwh
+		sim.AnnualSavings += result.Savings
+		totalSelf += result.SelfConsumedKwh
+		totalConsumption += result.ConsumptionKwh
+
+		result.ProductionKwh = roundTo(result.ProductionKwh, 2)
+		result.ConsumptionKwh = roundTo(result.ConsumptionKwh, 2)
+		result.SelfConsumedKwh = roundTo(result.SelfConsumedKwh, 2)
+		result.ExportKwh = roundTo(result.ExportKwh, 2)
+		result.ImportKwh = roundTo(result.ImportKwh, 2)
+		result.CreditUsedKwh = roundTo(result.CreditUsedKwh, 2)
+		sim.Months = append(sim.Months, result)
+	}
+
+	if sim.AnnualProduction > 0 {
+		sim.SelfConsumptionPct = roundTo(totalSelf/sim.AnnualProduction*100, 1)
+	}
+	if totalConsumption > 0 {
+		sim.SolarFractionPct = roundTo(totalSelf/totalConsumption*100, 1)
+	}
+	sim.AnnualProduction = roundTo(sim.AnnualProduction, 2)
+	sim.AnnualSavings = roundTo(sim.AnnualSavings, 0)
+	if sim.AnnualSavings > 0 {
+		years := roundTo(sim.SystemCost/sim.AnnualSavings, 1)
+		sim.PaybackYears = &years
+	}
+}
+
+func parsePositiveFloat(raw string, fallback float64) (float64, bool) {
+	if raw == "" {
+		return fallback, true
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+	return value, true
+}
+
+// SolarCitiesHandler mengembalikan daftar kota yang punya profil irradiasi
+func SolarCitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	cities, err := solarCities()
+	if err != nil {
+		log.Printf("❌ SolarCitiesHandler: Error querying irradiasi_kota: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data kota"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cities":       cities,
+		"orientations": orientationFactors,
+	})
+}
+
+// SolarSimulationHandler mensimulasikan PLTS atap.
+// Query: kwp (wajib), orientation, city, cost_per_kwp, export_rate (0-1).
+func SolarSimulationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	kwp, ok := parsePositiveFloat(query.Get("kwp"), 0)
+	if !ok || kwp <= 0 {
+		http.Error(w, `{"error": "Parameter kwp wajib diisi dan harus lebih dari 0"}`, http.StatusBadRequest)
+		return
+	}
+	costPerKWp, ok := parsePositiveFloat(query.Get("cost_per_kwp"), defaultCostPerKWp)
+	if !ok {
+		http.Error(w, `{"error": "Parameter cost_per_kwp tidak valid"}`, http.StatusBadRequest)
+		return
+	}
+	exportRate, ok := parsePositiveFloat(query.Get("export_rate"), defaultExportRate)
+	if !ok || exportRate > 1 {
+		http.Error(w, `{"error": "Parameter export_rate harus antara 0 dan 1"}`, http.StatusBadRequest)
+		return
+	}
+
+	orientation := strings.ToLower(strings.TrimSpace(query.Get("orientation")))
+	if orientation == "" {
+		orientation = "utara"
+	}
+	orientationFactor, ok := orientationFactors[orientation]
+	if !ok {
+		http.Error(w, `{"error": "Orientasi harus salah satu dari utara, selatan, timur, barat, datar"}`, http.StatusBadRequest)
+		return
+	}
+
+	city := strings.TrimSpace(query.Get("city"))
+	if city == "" {
+		city = defaultSolarCity
+	}
+	irradiance, found, err := loadIrradiance(city)
+	if err != nil {
+		log.Printf("❌ SolarSimulationHandler: Error loading irradiance: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data irradiasi"}`, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, `{"error": "Profil irradiasi untuk kota tersebut belum tersedia"}`, http.StatusNotFound)
+		return
+	}
+
+	records, err := latestSubmissionRecords(userID)
+	if err != nil {
+		log.Printf("❌ SolarSimulationHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+
+	sim := SolarSimulation{
+		City:              city,
+		KWp:               kwp,
+		Orientation:       orientation,
+		OrientationFactor: orientationFactor,
+		ExportRate:        exportRate,
+		SystemCost:        roundTo(kwp*costPerKWp, 0),
+		Warnings:          []string{},
+	}
+
+	// Profil konsumsi: data IoT kalau ada, selain itu dari jadwal perangkat
+	now := time.Now()
+	if profile, ok := measuredHourlyProfile(userID, now.AddDate(0, 0, -30)); ok {
+		sim.HourlyProfileKwh, sim.ProfileSource = profile, "measured"
+	} else if len(records) > 0 {
+		sim.HourlyProfileKwh, sim.ProfileSource = scheduledHourlyProfile(records), "schedule"
+	} else {
+		http.Error(w, `{"error": "Belum ada data pemakaian untuk disimulasikan"}`, http.StatusNotFound)
+		return
+	}
+
+	besarListrik := ""
+	if len(records) > 0 {
+		besarListrik = records[0].BesarListrik
+	}
+	// Kapasitas PLTS atap dibatasi maksimal 100% daya tersambung
+	if va, ok := parseCapacityVA(besarListrik); ok && kwp*1000 > float64(va) {
+		sim.Warnings = append(sim.Warnings, fmt.Sprintf("Kapasitas %.2f kWp melebihi daya tersambung %d VA; PLN membatasi PLTS atap maksimal 100%% daya tersambung", kwp, va))
+	}
+	if exportRate == 0 {
+		sim.Warnings = append(sim.Warnings, "Ekspor tidak diperhitungkan; kelebihan produksi tidak mengurangi tagihan")
+	}
+
+	simulateSolar(&sim, irradiance, besarListrik, userBillingProfile(userID), now.Year())
+	for i := range sim.HourlyProfileKwh {
+		sim.HourlyProfileKwh[i] = roundTo(sim.HourlyProfileKwh[i], 3)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sim)
+}
@@ -6,21 +6,28 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 )
 
 // Struct untuk respons agar konsisten dengan types.ts di frontend
 type ChartDataPoint struct {
-	Label string  `json:"label"`
-	Value float64 `json:"value"`
+	Label      string  `json:"label"`
+	Value      float64 `json:"value"`
+	Source     string  `json:"source"`
+	Confidence string  `json:"confidence"`
 }
 
 type CategoryChartData struct {
+	CategoryID *int    `json:"category_id"`
 	Name       string  `json:"name"`
 	Percentage float64 `json:"percentage"`
 	Color      string  `json:"color"`
 	TotalPower float64 `json:"total_power"`
+	TotalCost  float64 `json:"total_cost"`
+	Source     string  `json:"source"`
+	Confidence string  `json:"confidence"`
 }
 
 type DateRangeResponse struct {
@@ -44,52 +51,36 @@ func GetMonthlyStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, errQuery := db.DB.Query(`
-        SELECT
-            FLOOR((DAYOFMONTH(DATE(tanggal_input)) - 1) / 7) + 1 AS week_of_month,
-            SUM(daya * durasi) AS total_power_wh
-        FROM
-            riwayat_perangkat
-        WHERE
-            user_id = ?
-            AND MONTH(DATE(tanggal_input)) = MONTH(CURDATE())
-            AND YEAR(DATE(tanggal_input)) = YEAR(CURDATE())
-        GROUP BY
-            week_of_month
-        ORDER BY
-            week_of_month;
-    `, userID)
+	// Dengan parameter month/week: rincian per minggu kalender dan per hari untuk bulan mana pun.
+	// Tanpa parameter: respons lama (W1..W5 bulan berjalan) untuk klien yang belum diperbarui.
+	if query := r.URL.Query(); query.Has("month") || query.Has("week") {
+		writeMonthlyBreakdown(w, r, userID)
+		return
+	}
 
-	if errQuery != nil {
-		log.Printf("❌ GetMonthlyStatisticsHandler: Error executing query: %v", errQuery)
+	records, errRecords := loadApplianceRecords(userID)
+	if errRecords != nil {
+		log.Printf("❌ GetMonthlyStatisticsHandler: Error loading records: %v", errRecords)
 		http.Error(w, `{"error": "Gagal mengambil data statistik bulanan"}`, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	profile := userBillingProfile(userID)
 
-	powerByWeekNumber := make(map[int]float64)
-	for rows.Next() {
-		var weekOfMonth int
-		var totalPowerWh float64
-		if errScan := rows.Scan(&weekOfMonth, &totalPowerWh); errScan != nil {
-			log.Printf("❌ GetMonthlyStatisticsHandler: Error scanning row: %v", errScan)
-			http.Error(w, `{"error": "Gagal membaca data statistik bulanan"}`, http.StatusInternalServerError)
-			return
-		}
-		powerByWeekNumber[weekOfMonth] = totalPowerWh / 1000.0 // Wh ke kWh
-		log.Printf("✅ Month stats - Week %d: %.2f kWh", weekOfMonth, totalPowerWh/1000.0)
-	}
-	if errRows := rows.Err(); errRows != nil {
-		log.Printf("❌ GetMonthlyStatisticsHandler: Error after iterating rows: %v", errRows)
+	// W1..W5 = hari 1-7, 8-14, dst. pada bulan berjalan, sampai hari ini
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	days := buildUsageSeries(records, loadMeasuredUsage(userID, monthStart, tomorrow, now.Location()), profile, monthStart, tomorrow, "day")
+
+	numWeeksToDisplay := 5
+	weeks := make([]usageTotal, numWeeksToDisplay)
+	for i, day := range days {
+		weeks[i/7].merge(usageTotal{Kwh: day.Kwh, MeasuredKwh: day.MeasuredKwh, coverage: day.coverage})
 	}
 
 	var responseData []ChartDataPoint
-	numWeeksToDisplay := 5
-	for i := 1; i <= numWeeksToDisplay; i++ {
-		responseData = append(responseData, ChartDataPoint{
-			Label: fmt.Sprintf("W%d", i),
-			Value: powerByWeekNumber[i],
-		})
+	for i, week := range weeks {
+		responseData = append(responseData, chartPoint(fmt.Sprintf("W%d", i+1), week))
 	}
 
 	log.Printf("✅ Monthly statistics response: %+v", responseData)
@@ -97,6 +88,132 @@ func GetMonthlyStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(responseData)
 }
 
+type MonthlyWeekBucket struct {
+	Label       string  `json:"label"`
+	WeekStart   string  `json:"week_start"`
+	WeekEnd     string  `json:"week_end"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Days        int     `json:"days"`
+	Kwh         float64 `json:"kwh"`
+	Rp          float64 `json:"rp"`
+	MeasuredKwh float64 `json:"measured_kwh"`
+	Source      string  `json:"source"`
+	Confidence  string  `json:"confidence"`
+}
+
+type MonthlyBreakdownResponse struct {
+	Month    string              `json:"month"`
+	WeekMode string              `json:"week_mode"`
+	TotalKwh float64             `json:"total_kwh"`
+	TotalRp  float64             `json:"total_rp"`
+	Weeks    []MonthlyWeekBucket `json:"weeks"`
+	Days     []SeriesPoint       `json:"days"`
+}
+
+// writeMonthlyBreakdown: statistik bulan tertentu dengan minggu kalender (mulai Senin).
+// Minggu yang terpotong awal/akhir bulan tetap ditampilkan dengan rentang penuhnya (week_start..week_end),
+// tapi nilainya hanya dari hari di dalam bulan (from..to). week=iso memakai label minggu ISO 8601,
+// week=monday (default) memakai urutan W1, W2, ... di dalam bulan. Hari setelah hari ini tidak ikut.
+func writeMonthlyBreakdown(w http.ResponseWriter, r *http.Request, userID int) {
+	query := r.URL.Query()
+	weekMode := query.Get("week")
+	if weekMode == "" {
+		weekMode = "monday"
+	}
+	if weekMode != "iso" && weekMode != "monday" {
+		http.Error(w, `{"error": "week harus iso atau monday"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if raw := query.Get("month"); raw != "" {
+		parsed, err := time.ParseInLocation("2006-01", raw, now.Location())
+		if err != nil {
+			http.Error(w, `{"error": "Format month tidak valid, gunakan YYYY-MM"}`, http.StatusBadRequest)
+			return
+		}
+		monthStart = parsed
+	}
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	until := monthEnd
+	if tomorrow.Before(until) {
+		until = tomorrow
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ GetMonthlyStatisticsHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data statistik bulanan"}`, http.StatusInternalServerError)
+		return
+	}
+	profile := userBillingProfile(userID)
+
+	response := MonthlyBreakdownResponse{
+		Month:    monthStart.Format("2006-01"),
+		WeekMode: weekMode,
+		Weeks:    []MonthlyWeekBucket{},
+		Days:     []SeriesPoint{},
+	}
+	if until.After(monthStart) {
+		response.Days = buildUsageSeries(records, loadMeasuredUsage(userID, monthStart, until, now.Location()), profile, monthStart, until, "day")
+	}
+	daily := make(map[string]SeriesPoint)
+	for _, day := range response.Days {
+		daily[day.Label] = day
+		response.TotalKwh += day.Kwh
+		response.TotalRp += day.Rp
+	}
+	response.TotalKwh = roundTo(response.TotalKwh, 3)
+	response.TotalRp = roundTo(response.TotalRp, 0)
+
+	for weekStart := bucketStart(monthStart, "week"); weekStart.Before(monthEnd); weekStart = weekStart.AddDate(0, 0, 7) {
+		from := weekStart
+		if from.Before(monthStart) {
+			from = monthStart
+		}
+		to := weekStart.AddDate(0, 0, 7)
+		if to.After(monthEnd) {
+			to = monthEnd
+		}
+
+		bucket := MonthlyWeekBucket{
+			Label:     fmt.Sprintf("W%d", len(response.Weeks)+1),
+			WeekStart: weekStart.Format("2006-01-02"),
+			WeekEnd:   weekStart.AddDate(0, 0, 6).Format("2006-01-02"),
+			From:      from.Format("2006-01-02"),
+			To:        to.AddDate(0, 0, -1).Format("2006-01-02"),
+		}
+		if weekMode == "iso" {
+			bucket.Label = bucketLabel(weekStart, "week")
+		}
+		// Sumber minggu dari jumlah jam terukur seluruh harinya
+		var coverage seriesCoverage
+		for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+			bucket.Days++
+			point, exists := daily[day.Format("2006-01-02")]
+			if !exists {
+				continue
+			}
+			bucket.Kwh += point.Kwh
+			bucket.Rp += point.Rp
+			bucket.MeasuredKwh += point.MeasuredKwh
+			coverage.Hours += point.coverage.Hours
+			coverage.MeasuredHours += point.coverage.MeasuredHours
+		}
+		bucket.Source, bucket.Confidence = coverage.label(bucket.Kwh, bucket.MeasuredKwh)
+		bucket.MeasuredKwh = roundTo(bucket.MeasuredKwh, 3)
+		bucket.Kwh = roundTo(bucket.Kwh, 3)
+		bucket.Rp = roundTo(bucket.Rp, 0)
+		response.Weeks = append(response.Weeks, bucket)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // FUNGSI YANG DIPERBAIKI: GetWeeklyStatisticsHandler
 func GetWeeklyStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 	session, errSession := Store.Get(r, "elektronik_rumah_session")
@@ -126,7 +243,7 @@ func GetWeeklyStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 		targetDateForWeek = time.Now().Local()
 		log.Printf("✅ GetWeeklyStatisticsHandler: No date param, using current date: %s", targetDateForWeek.Format("2006-01-02"))
 	} else {
-		targetDateForWeek, errParse = time.Parse("2006-01-02", dateQueryParam)
+		targetDateForWeek, errParse = time.ParseInLocation("2006-01-02", dateQueryParam, time.Local)
 		if errParse != nil {
 			log.Printf("❌ GetWeeklyStatisticsHandler: Invalid date format: '%s', error: %v", dateQueryParam, errParse)
 			http.Error(w, `{"error": "Format tanggal tidak valid, gunakan YYYY-MM-DD"}`, http.StatusBadRequest)
@@ -151,76 +268,33 @@ func GetWeeklyStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ GetWeeklyStatisticsHandler: UserID: %d, Target: %s, StartOfWeek: %s, EndOfWeek: %s",
 		userID, targetDateForWeek.Format("2006-01-02"), startOfWeek.Format("2006-01-02"), endOfWeek.Format("2006-01-02"))
 
-	query := `
-		SELECT
-			DATE(tanggal_input) AS day_date,
-			SUM(daya * durasi) AS total_power_wh
-		FROM
-			riwayat_perangkat
-		WHERE
-			user_id = ?
-			AND DATE(tanggal_input) >= ?
-			AND DATE(tanggal_input) <= ?
-		GROUP BY
-			DATE(tanggal_input)
-		ORDER BY
-			day_date;`
-
-	rows, errQuery := db.DB.Query(query, userID, startOfWeek.Format("2006-01-02"), endOfWeek.Format("2006-01-02"))
-	if errQuery != nil {
-		log.Printf("❌ GetWeeklyStatisticsHandler: Error executing query: %v", errQuery)
+	records, errRecords := loadApplianceRecords(userID)
+	if errRecords != nil {
+		log.Printf("❌ GetWeeklyStatisticsHandler: Error loading records: %v", errRecords)
 		http.Error(w, `{"error": "Gagal mengambil data statistik mingguan"}`, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	profile := userBillingProfile(userID)
 
-	powerByDate := make(map[string]float64)
-	for rows.Next() {
-		var dayDateStr string
-		var totalPowerWh float64
-		if errScan := rows.Scan(&dayDateStr, &totalPowerWh); errScan != nil {
-			log.Printf("❌ GetWeeklyStatisticsHandler: Error scanning row: %v", errScan)
-			http.Error(w, `{"error": "Gagal membaca data statistik mingguan"}`, http.StatusInternalServerError)
-			return
-		}
-
-		// === PERBAIKAN UTAMA DI SINI ===
-		// Database mengembalikan string format RFC3339 (contoh: 2025-12-02T00:00:00Z)
-		// Kita perlu potong biar jadi YYYY-MM-DD saja agar cocok dengan kuncinya
-		
-		cleanDateKey := dayDateStr
-		// Jika ada huruf 'T', ambil bagian depannya saja
-		if idx := strings.Index(dayDateStr, "T"); idx != -1 {
-			cleanDateKey = dayDateStr[:idx]
-		} else if len(dayDateStr) >= 10 {
-			// Fallback: ambil 10 karakter pertama
-			cleanDateKey = dayDateStr[:10]
-		}
-		
-		powerByDate[cleanDateKey] = totalPowerWh / 1000.0 // Wh ke kWh
-		log.Printf("✅ Weekly data found - RAW: %s, CLEAN KEY: %s, Power: %.2f kWh", dayDateStr, cleanDateKey, totalPowerWh/1000.0)
+	// Hari setelah hari ini tidak dihitung (tetap tampil dengan nilai 0)
+	now := time.Now()
+	until := startOfWeek.AddDate(0, 0, 7)
+	if tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, startOfWeek.Location()); tomorrow.Before(until) {
+		until = tomorrow
 	}
-	if errRows := rows.Err(); errRows != nil {
-		log.Printf("❌ GetWeeklyStatisticsHandler: Error after iterating rows: %v", errRows)
+	var days []SeriesPoint
+	if until.After(startOfWeek) {
+		days = buildUsageSeries(records, loadMeasuredUsage(userID, startOfWeek, until, startOfWeek.Location()), profile, startOfWeek, until, "day")
 	}
 
-	log.Printf("✅ Power by date map: %+v", powerByDate)
-
 	var responseData []ChartDataPoint
 	daysOfWeekLabels := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
-	currentDayInLoop := startOfWeek
-
-	for i := 0; i < 7; i++ {
-		dateKey := currentDayInLoop.Format("2006-01-02")
-		value := powerByDate[dateKey]
-		responseData = append(responseData, ChartDataPoint{
-			Label: daysOfWeekLabels[i],
-			Value: value,
-		})
-		// Log lebih detail buat debugging
-		log.Printf("🔍 Looking for key [%s] -> Found: %.2f kWh", dateKey, value)
-		
-		currentDayInLoop = currentDayInLoop.AddDate(0, 0, 1)
+	for i, label := range daysOfWeekLabels {
+		var day usageTotal
+		if i < len(days) {
+			day = usageTotal{Kwh: days[i].Kwh, MeasuredKwh: days[i].MeasuredKwh, coverage: days[i].coverage}
+		}
+		responseData = append(responseData, chartPoint(label, day))
 	}
 
 	log.Printf("✅ Weekly statistics response: %+v", responseData)
@@ -228,7 +302,66 @@ func GetWeeklyStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(responseData)
 }
 
+// chartPoint: titik grafik lama (label + kWh) beserta sumber dan tingkat keyakinannya
+func chartPoint(label string, total usageTotal) ChartDataPoint {
+	point := ChartDataPoint{Label: label, Value: roundTo(total.Kwh, 3)}
+	point.Source, point.Confidence = total.label()
+	return point
+}
+
+// categoryPalette: warna tetap per kategori (berdasarkan kategori_id, bukan urutan peringkat)
+var categoryPalette = []string{"#3B82F6", "#48C353", "#9333EA", "#FF8C33", "#EF4444", "#F59E0B", "#10B981", "#6366F1"}
+
+const uncategorizedColor = "#9CA3AF"
+
+func categoryColor(categoryID *int) string {
+	if categoryID == nil {
+		return uncategorizedColor
+	}
+	return categoryPalette[(*categoryID-1+len(categoryPalette))%len(categoryPalette)]
+}
+
+type categoryTotal struct {
+	ID   *int
+	Name string
+	usageTotal
+}
+
+// statisticsRange: rentang default statistik kategori/ruangan, dari hari riwayat pertama sampai hari ini
+func statisticsRange(records []applianceRecord, loc *time.Location) (time.Time, time.Time) {
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	if len(records) == 0 {
+		return to, to
+	}
+	first := records[0].InputDate.In(loc)
+	return time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc), to
+}
+
+// categoryTotalsInRange: pemakaian per kategori pada [from, to), data terukur digabung lewat forEachUsageHour.
+// Energi meter utama yang tidak bisa dibagi ke perangkat masuk ke Uncategorised.
+func categoryTotalsInRange(records []applianceRecord, measured measuredUsage, profile BillingProfile, from, to time.Time) map[string]*categoryTotal {
+	totals := make(map[string]*categoryTotal)
+	forEachUsageHour(records, measured, profile, from, to, func(hour usageHour) {
+		for key, kwh := range hour.Kwh {
+			rec := hour.Records[key]
+			totalKey, name := "none", uncategorizedLabel
+			if rec.CategoryID != nil {
+				totalKey, name = fmt.Sprintf("%d", *rec.CategoryID), rec.CategoryName
+			}
+			total, exists := totals[totalKey]
+			if !exists {
+				total = &categoryTotal{ID: rec.CategoryID, Name: name}
+				totals[totalKey] = total
+			}
+			total.add(kwh, hour.Rate, hour.Measured[key])
+		}
+	})
+	return totals
+}
+
 // GetCategoryStatisticsHandler
+// Query opsional from/to (YYYY-MM-DD): pemakaian pada rentang tersebut; tanpa parameter dari hari riwayat pertama sampai hari ini.
 func GetCategoryStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 	session, errSession := Store.Get(r, "elektronik_rumah_session")
 	if errSession != nil {
@@ -244,67 +377,57 @@ func GetCategoryStatisticsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, errQuery := db.DB.Query(`
-        SELECT
-            k.nama_kategori,
-            COALESCE(SUM(rp.daya * rp.durasi), 0) AS total_power_wh
-        FROM
-            kategori k
-        LEFT JOIN
-            riwayat_perangkat rp ON rp.kategori_id = k.kategori_id 
-            AND rp.user_id = ?
-        GROUP BY
-            k.kategori_id, k.nama_kategori
-        HAVING
-            SUM(rp.daya * rp.durasi) > 0
-        ORDER BY
-            total_power_wh DESC;
-    `, userID)
-
-	if errQuery != nil {
-		log.Printf("❌ GetCategoryStatisticsHandler: Error executing query: %v", errQuery)
+	records, errRecords := loadApplianceRecords(userID)
+	if errRecords != nil {
+		log.Printf("❌ GetCategoryStatisticsHandler: Error loading records: %v", errRecords)
 		http.Error(w, `{"error": "Gagal mengambil data statistik kategori"}`, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var categoryStatsTemp []CategoryChartData
-	var totalOverallPowerKWh float64
-
-	for rows.Next() {
-		var categoryName string
-		var totalPowerWh float64
-		if errScan := rows.Scan(&categoryName, &totalPowerWh); errScan != nil {
-			log.Printf("❌ GetCategoryStatisticsHandler: Error scanning row: %v", errScan)
-			http.Error(w, `{"error": "Gagal membaca data statistik kategori"}`, http.StatusInternalServerError)
+	profile := userBillingProfile(userID)
+
+	from, to := statisticsRange(records, time.Local)
+	query := r.URL.Query()
+	if query.Has("from") || query.Has("to") {
+		var errRange error
+		from, to, errRange = parseSeriesRange(query.Get("from"), query.Get("to"), time.Local)
+		if errRange != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errRange.Error()), http.StatusBadRequest)
 			return
 		}
-		currentCategoryPowerKWh := totalPowerWh / 1000.0
-		categoryStatsTemp = append(categoryStatsTemp, CategoryChartData{
-			Name:       categoryName,
-			TotalPower: currentCategoryPowerKWh,
-		})
-		totalOverallPowerKWh += currentCategoryPowerKWh
-		log.Printf("✅ Category: %s, Power: %.2f kWh", categoryName, currentCategoryPowerKWh)
 	}
-	if errRows := rows.Err(); errRows != nil {
-		log.Printf("❌ GetCategoryStatisticsHandler: Error after iterating rows: %v", errRows)
+	totals := categoryTotalsInRange(records, loadMeasuredUsage(userID, from, to, time.Local), profile, from, to)
+
+	var totalOverallPowerKWh float64
+	for _, total := range totals {
+		totalOverallPowerKWh += total.Kwh
 	}
 
-	var finalCategoryStats []CategoryChartData
-	defaultColors := []string{"#3B82F6", "#48C353", "#9333EA", "#FF8C33", "#EF4444", "#F59E0B", "#10B981", "#6366F1"}
-	for i, stat := range categoryStatsTemp {
+	finalCategoryStats := []CategoryChartData{}
+	for _, total := range totals {
+		if total.Kwh <= 0 {
+			continue
+		}
 		percentage := 0.0
 		if totalOverallPowerKWh > 0 {
-			percentage = (stat.TotalPower / totalOverallPowerKWh) * 100
+			percentage = (total.Kwh / totalOverallPowerKWh) * 100
 		}
-		finalCategoryStats = append(finalCategoryStats, CategoryChartData{
-			Name:       stat.Name,
-			TotalPower: stat.TotalPower,
+		stat := CategoryChartData{
+			CategoryID: total.ID,
+			Name:       total.Name,
+			TotalPower: total.Kwh,
+			TotalCost:  roundTo(total.Rp, 0),
 			Percentage: percentage,
-			Color:      defaultColors[i%len(defaultColors)],
-		})
+			Color:      categoryColor(total.ID),
+		}
+		stat.Source, stat.Confidence = total.label()
+		finalCategoryStats = append(finalCategoryStats, stat)
 	}
+	sort.Slice(finalCategoryStats, func(i, j int) bool {
+		if finalCategoryStats[i].TotalPower != finalCategoryStats[j].TotalPower {
+			return finalCategoryStats[i].TotalPower > finalCategoryStats[j].TotalPower
+		}
+		return finalCategoryStats[i].Name < finalCategoryStats[j].Name
+	})
 
 	log.Printf("✅ Category statistics response: %+v", finalCategoryStats)
 	w.Header().Set("Content-Type", "application/json")
@@ -333,7 +456,7 @@ func GetDataRangeHandler(w http.ResponseWriter, r *http.Request) {
 			MIN(DATE(tanggal_input)), 
 			MAX(DATE(tanggal_input)) 
 		FROM riwayat_perangkat 
-		WHERE user_id = ?
+		WHERE user_id = ? AND deleted_at IS NULL
 	`
 	errQuery := db.DB.QueryRow(query, userID).Scan(&response.FirstDate, &response.LastDate)
 	if errQuery != nil {
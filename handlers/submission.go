@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type SubmissionSummary struct {
+	IDSubmit     string  `json:"id_submit"`
+	Date         string  `json:"tanggal_input"`
+	TotalItems   int     `json:"total_items"`
+	BesarListrik string  `json:"besar_listrik"`
+	DailyKwh     float64 `json:"daily_kwh"`
+	MonthlyKwh   float64 `json:"monthly_kwh"`
+	MonthlyCost  float64 `json:"monthly_cost"`
+	MonthlyBill  float64 `json:"monthly_bill"`
+}
+
+type ApplianceSnapshot struct {
+	ID          int     `json:"id"`
+	Power       float64 `json:"daya"`
+	DailyHours  float64 `json:"daily_hours"`
+	Quantity    int     `json:"jumlah"`
+	MonthlyKwh  float64 `json:"monthly_kwh"`
+	MonthlyCost float64 `json:"monthly_cost"`
+}
+
+type ApplianceChange struct {
+	Name           string             `json:"name"`
+	Brand          string             `json:"brand"`
+	Before         *ApplianceSnapshot `json:"before"`
+	After          *ApplianceSnapshot `json:"after"`
+	ChangedFields  []string           `json:"changed_fields"`
+	MonthlyKwhDiff float64            `json:"monthly_kwh_delta"`
+	MonthlyRpDiff  float64            `json:"monthly_cost_delta"`
+}
+
+type SubmissionDiffResponse struct {
+	From             SubmissionSummary `json:"from"`
+	To               SubmissionSummary `json:"to"`
+	Added            []ApplianceChange `json:"added"`
+	Removed          []ApplianceChange `json:"removed"`
+	Changed          []ApplianceChange `json:"changed"`
+	UnchangedCount   int               `json:"unchanged_count"`
+	MonthlyKwhDelta  float64           `json:"monthly_kwh_delta"`
+	MonthlyCostDelta float64           `json:"monthly_cost_delta"`
+}
+
+func recordSnapshot(rec applianceRecord, subsidized bool) *ApplianceSnapshot {
+	monthlyKwh := rec.averageDailyKWh() * 30
+	return &ApplianceSnapshot{
+		ID:          rec.ID,
+		Power:       rec.Power,
+		DailyHours:  roundTo(averageDailyHours(rec.Schedule, rec.Duration), 2),
+		Quantity:    rec.Quantity,
+		MonthlyKwh:  monthlyKwh,
+		MonthlyCost: monthlyKwh * tariffPerKwhAt(rec.BesarListrik, subsidized, rec.InputDate),
+	}
+}
+
+// summarizeSubmissions mengelompokkan riwayat per id_submit, terbaru di atas
+func summarizeSubmissions(records []applianceRecord, subsidized bool) []SubmissionSummary {
+	byID := make(map[string]*SubmissionSummary)
+	var order []string
+	for _, rec := range records {
+		summary, exists := byID[rec.IDSubmit]
+		if !exists {
+			summary = &SubmissionSummary{
+				IDSubmit:     rec.IDSubmit,
+				Date:         rec.InputDate.Format("2006-01-02"),
+				BesarListrik: rec.BesarListrik,
+			}
+			byID[rec.IDSubmit] = summary
+			order = append(order, rec.IDSubmit)
+		}
+		snapshot := recordSnapshot(rec, subsidized)
+		summary.TotalItems++
+		summary.DailyKwh += snapshot.MonthlyKwh / 30
+		summary.MonthlyKwh += snapshot.MonthlyKwh
+		summary.MonthlyCost += snapshot.MonthlyCost
+	}
+
+	summaries := make([]SubmissionSummary, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		summary := *byID[order[i]]
+		summary.DailyKwh = roundTo(summary.DailyKwh, 2)
+		summary.MonthlyKwh = roundTo(summary.MonthlyKwh, 2)
+		summary.MonthlyCost = roundTo(summary.MonthlyCost, 0)
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// applyBillTotals mengisi monthly_bill (rekening lengkap dengan PPJ/admin/materai) tiap ringkasan
+func applyBillTotals(userID int, summaries []SubmissionSummary) {
+	profile := userBillingProfile(userID)
+	now := time.Now()
+	for i := range summaries {
+		summaries[i].MonthlyBill = calculateBill(summaries[i].MonthlyKwh, summaries[i].BesarListrik, profile, now).Total
+	}
+}
+
+// keyedRecords memberi kunci nama+merek; kalau ada dua perangkat sama dalam satu batch, jadi "key#2" dst.
+func keyedRecords(records []applianceRecord) (map[string]applianceRecord, []string) {
+	keyed := make(map[string]applianceRecord)
+	var keys []string
+	seen := make(map[string]int)
+	for _, rec := range records {
+		key := rec.identityKey()
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s#%d", key, seen[key])
+		}
+		keyed[key] = rec
+		keys = append(keys, key)
+	}
+	return keyed, keys
+}
+
+func diffSubmissions(fromRecords, toRecords []applianceRecord, subsidized bool) SubmissionDiffResponse {
+	diff := SubmissionDiffResponse{
+		Added:   []ApplianceChange{},
+		Removed: []ApplianceChange{},
+		Changed: []ApplianceChange{},
+	}
+	fromByKey, fromKeys := keyedRecords(fromRecords)
+	toByKey, toKeys := keyedRecords(toRecords)
+
+	for _, key := range toKeys {
+		after := toByKey[key]
+		afterSnapshot := recordSnapshot(after, subsidized)
+		before, existed := fromByKey[key]
+		if !existed {
+			diff.Added = append(diff.Added, ApplianceChange{
+				Name: after.Name, Brand: after.Brand, After: afterSnapshot, ChangedFields: []string{},
+				MonthlyKwhDiff: roundTo(afterSnapshot.MonthlyKwh, 2),
+				MonthlyRpDiff:  roundTo(afterSnapshot.MonthlyCost, 0),
+			})
+			continue
+		}
+
+		beforeSnapshot := recordSnapshot(before, subsidized)
+		changed := []string{}
+		if before.Power != after.Power {
+			changed = append(changed, "daya")
+		}
+		if beforeSnapshot.DailyHours != afterSnapshot.DailyHours {
+			changed = append(changed, "durasi")
+		}
+		if before.Quantity != after.Quantity {
+			changed = append(changed, "jumlah")
+		}
+		if before.BesarListrik != after.BesarListrik {
+			changed = append(changed, "besar_listrik")
+		}
+		if len(changed) == 0 {
+			diff.UnchangedCount++
+			continue
+		}
+		diff.Changed = append(diff.Changed, ApplianceChange{
+			Name: after.Name, Brand: after.Brand, Before: beforeSnapshot, After: afterSnapshot, ChangedFields: changed,
+			MonthlyKwhDiff: roundTo(afterSnapshot.MonthlyKwh-beforeSnapshot.MonthlyKwh, 2),
+			MonthlyRpDiff:  roundTo(afterSnapshot.MonthlyCost-beforeSnapshot.MonthlyCost, 0),
+		})
+	}
+
+	for _, key := range fromKeys {
+		if _, stillThere := toByKey[key]; stillThere {
+			continue
+		}
+		before := fromByKey[key]
+		beforeSnapshot := recordSnapshot(before, subsidized)
+		diff.Removed = append(diff.Removed, ApplianceChange{
+			Name: before.Name, Brand: before.Brand, Before: beforeSnapshot, ChangedFields: []string{},
+			MonthlyKwhDiff: roundTo(-beforeSnapshot.MonthlyKwh, 2),
+			MonthlyRpDiff:  roundTo(-beforeSnapshot.MonthlyCost, 0),
+		})
+	}
+
+	// Perubahan terbesar (dalam Rupiah) di atas
+	sort.SliceStable(diff.Changed, func(i, j int) bool {
+		return math.Abs(diff.Changed[i].MonthlyRpDiff) > math.Abs(diff.Changed[j].MonthlyRpDiff)
+	})
+	return diff
+}
+
+// GetSubmissionsHandler menampilkan semua batch submit user beserta total kWh dan biaya
+func GetSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ GetSubmissionsHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data submit"}`, http.StatusInternalServerError)
+		return
+	}
+
+	summaries := summarizeSubmissions(records, userSubsidized(userID))
+	applyBillTotals(userID, summaries)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// DiffSubmissionsHandler membandingkan dua batch: ?from=<id_submit lama>&to=<id_submit baru>
+func DiffSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	fromID := r.URL.Query().Get("from")
+	toID := r.URL.Query().Get("to")
+	if fromID == "" || toID == "" {
+		http.Error(w, `{"error": "Parameter from dan to (id_submit) wajib diisi"}`, http.StatusBadRequest)
+		return
+	}
+
+	records, err := loadApplianceRecords(userID, fromID, toID)
+	if err != nil {
+		log.Printf("❌ DiffSubmissionsHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data submit"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var fromRecords, toRecords []applianceRecord
+	for _, rec := range records {
+		if rec.IDSubmit == fromID {
+			fromRecords = append(fromRecords, rec)
+		}
+		if rec.IDSubmit == toID {
+			toRecords = append(toRecords, rec)
+		}
+	}
+	if len(fromRecords) == 0 || len(toRecords) == 0 {
+		http.Error(w, `{"error": "id_submit tidak ditemukan"}`, http.StatusNotFound)
+		return
+	}
+
+	subsidized := userSubsidized(userID)
+	diff := diffSubmissions(fromRecords, toRecords, subsidized)
+	summaries := append(summarizeSubmissions(fromRecords, subsidized), summarizeSubmissions(toRecords, subsidized)...)
+	applyBillTotals(userID, summaries)
+	diff.From, diff.To = summaries[0], summaries[1]
+	diff.MonthlyKwhDelta = roundTo(diff.To.MonthlyKwh-diff.From.MonthlyKwh, 2)
+	diff.MonthlyCostDelta = roundTo(diff.To.MonthlyCost-diff.From.MonthlyCost, 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"EnerTrack-BE/db"
+	"EnerTrack-BE/models"
 
 	"github.com/google/uuid"
 )
@@ -19,7 +21,12 @@ type DeviceInput struct {
 	Brand            string  `json:"brand"`
 	Power            float64 `json:"power"`
 	Duration         float64 `json:"duration"`
+	DurationMinutes  float64 `json:"duration_minutes"`
+	Quantity         int     `json:"quantity"`
 	CategoryID       *int    `json:"category_id"`
+	RoomID           *int    `json:"room_id"`
+	// Schedule opsional; kalau kosong, jadwal perangkat yang sama (nama + merek) di submit terakhir ikut terbawa
+	Schedule []models.UsageWindow `json:"schedule"`
 }
 
 // getCurrentDate mengembalikan tanggal saat ini dalam format YYYY-MM-DD
@@ -71,6 +78,7 @@ func SubmitHandler(w http.ResponseWriter, r *http.Request) {
 		Electricity struct {
 			Amount float64 `json:"amount,omitempty"`
 			Kwh    float64 `json:"kwh,omitempty"`
+			Period string  `json:"period,omitempty"` // YYYY-MM, default bulan lalu (tagihan pascabayar)
 		} `json:"electricity"`
 		Devices []DeviceInput `json:"devices"`
 	}
@@ -92,6 +100,13 @@ func SubmitHandler(w http.ResponseWriter, r *http.Request) {
 	idSubmit := uuid.New().String()
 	log.Printf("✅ id_submit dibuat: %s", idSubmit)
 
+	// Profil tagihan (wilayah, subsidi) dan jadwal submit terakhir dibaca sebelum transaksi dimulai
+	profile := userBillingProfile(userID)
+	previousSchedules, err := latestSchedulesByIdentity(userID)
+	if err != nil {
+		log.Printf("⚠️ Gagal memuat jadwal submit sebelumnya: %v", err)
+	}
+
 	// Gunakan transaksi untuk menyimpan seluruh device dengan id_submit yang sama
 	tanggal := getCurrentDate()
 	tx, err := db.DB.Begin()
@@ -103,18 +118,38 @@ func SubmitHandler(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback() // rollback jika gagal
 
 	// Simpan setiap device dengan id_submit yang sama
+	batchMonthlyKwh := 0.0
 	for _, device := range inputData.Devices {
+		device.Duration = resolveDurationHours(device.Duration, device.DurationMinutes)
+		if err := validateSchedule(device.Schedule); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, fmt.Sprintf("Jadwal %s tidak valid: %v", device.Name, err)), http.StatusBadRequest)
+			return
+		}
+		if len(device.Schedule) == 0 {
+			device.Schedule = previousSchedules[applianceRecord{Name: device.Name, Brand: device.Brand}.identityKey()]
+		}
+		// Dengan jadwal, durasi boleh kosong (diisi rata-rata jam nyala per hari dari jadwal)
+		if device.Duration <= 0 && len(device.Schedule) > 0 {
+			device.Duration = averageDailyHours(device.Schedule, 0)
+		}
+		if device.Duration > 24 {
+			http.Error(w, `{"error": "Durasi pemakaian tidak boleh lebih dari 24 jam per hari"}`, http.StatusBadRequest)
+			return
+		}
 		if device.Jenis_Pembayaran == "" || device.Besar_Listrik == "" || device.Name == "" || device.Brand == "" || device.Power <= 0 || device.Duration <= 0 {
 			log.Println("❌ Data perangkat tidak valid:", device)
-			http.Error(w, `{"error": "Nama, merek, daya, dan durasi harus diisi dan lebih besar dari 0"}`, http.StatusBadRequest)
+			http.Error(w, `{"error": "Nama, merek, daya, dan durasi (jam atau menit) harus diisi dan lebih besar dari 0"}`, http.StatusBadRequest)
 			return
 		}
 
-		// Hitung weekly dan monthly usage
-		weeklyUsage := (device.Power * device.Duration * 7) / 1000.0 // kWh per minggu
+		quantity := normalizeQuantity(device.Quantity)
+
+		// Hitung weekly dan monthly usage (mengikuti jadwal kalau ada)
+		weeklyUsage := (device.Power * averageDailyHours(device.Schedule, device.Duration) * float64(quantity) * 7) / 1000.0 // kWh per minggu
 		monthlyUsage := float64(weeklyUsage * 4)                     // kWh per bulan
-		tariffRate := getTariffRate(device.Besar_Listrik)
+		tariffRate := tariffPerKwh(device.Besar_Listrik, profile.Subsidized)
 		monthlyCost := monthlyUsage * tariffRate
+		batchMonthlyKwh += monthlyUsage
 
 		// Handle category_id yang bisa null
 		var categoryID interface{}
@@ -124,11 +159,17 @@ func SubmitHandler(w http.ResponseWriter, r *http.Request) {
 			categoryID = nil // Akan menjadi NULL di database
 		}
 
-		_, err := tx.Exec(`
+		roomID := nullableRoomID(device.RoomID)
+		if roomID != nil && !roomBelongsToUser(roomID.(int), userID) {
+			http.Error(w, `{"error": "Ruangan tidak ditemukan"}`, http.StatusBadRequest)
+			return
+		}
+
+		result, err := tx.Exec(`
             INSERT INTO riwayat_perangkat 
-            (id_submit, user_id, Jenis_Pembayaran, Besar_Listrik, nama_perangkat, merek, daya, durasi, Weekly_Usage, Monthly_Usage, Monthly_cost, tanggal_input, kategori_id) 
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			idSubmit, userID, device.Jenis_Pembayaran, device.Besar_Listrik, device.Name, device.Brand, device.Power, device.Duration, weeklyUsage, monthlyUsage, monthlyCost, tanggal, categoryID,
+            (id_submit, user_id, Jenis_Pembayaran, Besar_Listrik, nama_perangkat, merek, daya, durasi, jumlah, Weekly_Usage, Monthly_Usage, Monthly_cost, tanggal_input, kategori_id, ruangan_id) 
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			idSubmit, userID, device.Jenis_Pembayaran, device.Besar_Listrik, device.Name, device.Brand, device.Power, device.Duration, quantity, weeklyUsage, monthlyUsage, monthlyCost, tanggal, categoryID, roomID,
 		)
 
 		if err != nil {
@@ -136,11 +177,56 @@ func SubmitHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error": "Gagal menyimpan data perangkat"}`, http.StatusInternalServerError)
 			return
 		}
+		if len(device.Schedule) > 0 {
+			riwayatID, err := result.LastInsertId()
+			if err == nil {
+				err = saveSchedule(tx, int(riwayatID), device.Schedule)
+			}
+			if err != nil {
+				log.Printf("❌ Gagal menyimpan jadwal perangkat: %v", err)
+				http.Error(w, `{"error": "Gagal menyimpan jadwal perangkat"}`, http.StatusInternalServerError)
+				return
+			}
+		}
 
 		// ✅ Debug log untuk memastikan CategoryID diterima
 		log.Printf("✅ Device saved with CategoryID: %v", categoryID)
 	}
 
+	// Pelanggan prabayar: sisa kWh yang diisi di form dicatat sebagai pembacaan meter
+	billingType := inputData.BillingType
+	if billingType == "" {
+		billingType = inputData.Devices[0].Jenis_Pembayaran
+	}
+	if isPrepaidBilling(billingType) && inputData.Electricity.Kwh > 0 {
+		if _, err := recordMeterReading(tx, userID, inputData.Electricity.Kwh, time.Now(), "submit"); err != nil {
+			log.Printf("❌ Gagal menyimpan saldo meter: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan saldo meter"}`, http.StatusInternalServerError)
+			return
+		}
+	} else if !isPrepaidBilling(billingType) && (inputData.Electricity.Amount > 0 || inputData.Electricity.Kwh > 0) {
+		// Pascabayar: nominal/kWh tagihan disimpan untuk rekonsiliasi dengan perkiraan
+		bill := ElectricityBill{Period: inputData.Electricity.Period, Source: "submit"}
+		if bill.Period == "" {
+			bill.Period = previousBillingPeriod(time.Now())
+		}
+		if _, err := time.Parse("2006-01", bill.Period); err != nil {
+			http.Error(w, `{"error": "Format periode tagihan harus YYYY-MM"}`, http.StatusBadRequest)
+			return
+		}
+		if inputData.Electricity.Amount > 0 {
+			bill.Amount = &inputData.Electricity.Amount
+		}
+		if inputData.Electricity.Kwh > 0 {
+			bill.Kwh = &inputData.Electricity.Kwh
+		}
+		if err := saveBill(tx, userID, bill); err != nil {
+			log.Printf("❌ Gagal menyimpan tagihan: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan tagihan listrik"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Commit transaksi
 	if err := tx.Commit(); err != nil {
 		log.Printf("❌ Gagal commit transaksi: %v", err)
@@ -148,12 +234,17 @@ func SubmitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Perkiraan rekening bulanan untuk batch ini (termasuk PPJ, admin, materai)
+	profile.Prepaid = isPrepaidBilling(billingType)
+	bill := calculateBill(batchMonthlyKwh, inputData.Devices[0].Besar_Listrik, profile, time.Now())
+
 	// Kirim respons sukses
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":     "Data berhasil disimpan",
-		"id_submit":   idSubmit,
-		"total_items": len(inputData.Devices),
+		"message":        "Data berhasil disimpan",
+		"id_submit":      idSubmit,
+		"total_items":    len(inputData.Devices),
+		"estimated_bill": bill,
 	})
 }
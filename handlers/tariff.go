@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fallbackTariffPerKwh dipakai kalau tabel tarif kosong / tidak terbaca (tarif R-1 1.300-2.200 VA)
+	fallbackTariffPerKwh = 1444.70
+	tariffCacheTTL       = 10 * time.Minute
+)
+
+// TariffRate: satu baris tarif_listrik
+type TariffRate struct {
+	ID            int     `json:"id"`
+	Class         string  `json:"golongan"`
+	MinVA         int     `json:"daya_min"`
+	MaxVA         *int    `json:"daya_max"`
+	Subsidized    bool    `json:"subsidi"`
+	PricePerKwh   float64 `json:"tarif_per_kwh"`
+	EffectiveFrom string  `json:"berlaku_mulai"`
+}
+
+func (rate TariffRate) covers(va int) bool {
+	return va >= rate.MinVA && (rate.MaxVA == nil || va <= *rate.MaxVA)
+}
+
+// tariffCache menyimpan seluruh tarif di memori; di-refresh tiap tariffCacheTTL atau setelah admin mengubah tarif
+var tariffCache struct {
+	sync.RWMutex
+	rates    []TariffRate
+	loadedAt time.Time
+}
+
+// parseCapacityVA mengubah teks daya ("1.300 VA", "2200VA", "6.600 VA and above") jadi angka VA
+func parseCapacityVA(capacity string) (int, bool) {
+	var digits strings.Builder
+	for _, ch := range strings.TrimSpace(capacity) {
+		switch {
+		case ch >= '0' && ch <= '9':
+			digits.WriteRune(ch)
+		case ch == '.' || ch == ',':
+			// pemisah ribuan, lewati
+		default:
+			if digits.Len() > 0 {
+				va, err := strconv.Atoi(digits.String())
+				return va, err == nil
+			}
+		}
+	}
+	va, err := strconv.Atoi(digits.String())
+	return va, err == nil && digits.Len() > 0
+}
+
+func loadTariffs() ([]TariffRate, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, golongan, daya_min, daya_max, subsidi, tarif_per_kwh, berlaku_mulai
+		FROM tarif_listrik
+		ORDER BY daya_min, subsidi, berlaku_mulai`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []TariffRate
+	for rows.Next() {
+		var rate TariffRate
+		var maxVA sql.NullInt64
+		var effectiveFrom time.Time
+		if err := rows.Scan(&rate.ID, &rate.Class, &rate.MinVA, &maxVA, &rate.Subsidized,
+			&rate.PricePerKwh, &effectiveFrom); err != nil {
+			return nil, err
+		}
+		if maxVA.Valid {
+			max := int(maxVA.Int64)
+			rate.MaxVA = &max
+		}
+		rate.EffectiveFrom = effectiveFrom.Format("2006-01-02")
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+func cachedTariffs() []TariffRate {
+	tariffCache.RLock()
+	rates, fresh := tariffCache.rates, time.Since(tariffCache.loadedAt) < tariffCacheTTL
+	tariffCache.RUnlock()
+	if fresh {
+		return rates
+	}
+
+	loaded, err := loadTariffs()
+	if err != nil {
+		log.Printf("❌ Gagal memuat tarif listrik, pakai cache lama: %v", err)
+		return rates
+	}
+	tariffCache.Lock()
+	tariffCache.rates = loaded
+	tariffCache.loadedAt = time.Now()
+	tariffCache.Unlock()
+	return loaded
+}
+
+func invalidateTariffCache() {
+	tariffCache.Lock()
+	tariffCache.loadedAt = time.Time{}
+	tariffCache.Unlock()
+}
+
+// lookupTariff mencari tarif untuk daya rumah pada tanggal tertentu.
+// Tarif non-subsidi diutamakan kecuali subsidized=true; kalau daya itu hanya punya tarif subsidi
+// (contoh 450 VA), tarif subsidi yang dipakai.
+func lookupTariff(besarListrik string, subsidized bool, at time.Time) (TariffRate, bool) {
+	va, ok := parseCapacityVA(besarListrik)
+	if !ok {
+		return TariffRate{}, false
+	}
+	day := at.Format("2006-01-02")
+
+	var best, fallback *TariffRate
+	rates := cachedTariffs()
+	for i := range rates {
+		rate := &rates[i]
+		if !rate.covers(va) || rate.EffectiveFrom > day {
+			continue
+		}
+		if rate.Subsidized == subsidized {
+			if best == nil || rate.EffectiveFrom > best.EffectiveFrom {
+				best = rate
+			}
+		} else if fallback == nil || rate.EffectiveFrom > fallback.EffectiveFrom {
+			fallback = rate
+		}
+	}
+	if best != nil {
+		return *best, true
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return TariffRate{}, false
+}
+
+// tariffPerKwhAt: Rp/kWh untuk daya rumah pada tanggal tertentu; subsidized dari profil user (lihat userSubsidized)
+func tariffPerKwhAt(besarListrik string, subsidized bool, at time.Time) float64 {
+	if rate, ok := lookupTariff(besarListrik, subsidized, at); ok {
+		return rate.PricePerKwh
+	}
+	return fallbackTariffPerKwh
+}
+
+// tariffPerKwh: Rp/kWh yang berlaku sekarang. Semua perhitungan biaya lewat sini.
+func tariffPerKwh(besarListrik string, subsidized bool) float64 {
+	return tariffPerKwhAt(besarListrik, subsidized, time.Now())
+}
+
+// userSubsidized: apakah user ditandai pelanggan bersubsidi di profil
+func userSubsidized(userID int) bool {
+	var subsidized bool
+	if err := db.DB.QueryRow("SELECT subsidi FROM users WHERE user_id = ?", userID).Scan(&subsidized); err != nil {
+		log.Printf("ℹ️ userSubsidized: status subsidi user %d tidak terbaca: %v", userID, err)
+	}
+	return subsidized
+}
+
+// requireAdmin memeriksa header X-Admin-Token terhadap env ADMIN_TOKEN
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	expected := os.Getenv("ADMIN_TOKEN")
+	if expected == "" {
+		http.Error(w, `{"error": "Admin API tidak aktif"}`, http.StatusForbidden)
+		return false
+	}
+	provided := r.Header.Get("X-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+		http.Error(w, `{"error": "Token admin tidak valid"}`, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// validateTariff memeriksa isi tarif dari admin; pesan error dalam format JSON
+func validateTariff(rate TariffRate) string {
+	if strings.TrimSpace(rate.Class) == "" {
+		return `{"error": "Golongan wajib diisi"}`
+	}
+	if rate.MinVA < 0 || (rate.MaxVA != nil && *rate.MaxVA < rate.MinVA) {
+		return `{"error": "Rentang daya tidak valid"}`
+	}
+	if rate.PricePerKwh <= 0 {
+		return `{"error": "Tarif per kWh harus lebih besar dari 0"}`
+	}
+	if _, err := time.Parse("2006-01-02", rate.EffectiveFrom); err != nil {
+		return `{"error": "Format berlaku_mulai harus YYYY-MM-DD"}`
+	}
+	return ""
+}
+
+// AdminTariffsHandler: GET daftar tarif, POST tarif baru (misal penyesuaian per triwulan),
+// PUT koreksi tarif, DELETE ?id= hapus tarif. Wajib header X-Admin-Token.
+func AdminTariffsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rates, err := loadTariffs()
+		if err != nil {
+			log.Printf("❌ AdminTariffsHandler: Error loading tariffs: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data tarif"}`, http.StatusInternalServerError)
+			return
+		}
+		if rates == nil {
+			rates = []TariffRate{}
+		}
+		sort.SliceStable(rates, func(i, j int) bool {
+			return rates[i].EffectiveFrom > rates[j].EffectiveFrom
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rates)
+
+	case http.MethodPost, http.MethodPut:
+		var rate TariffRate
+		if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if msg := validateTariff(rate); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		var maxVA interface{}
+		if rate.MaxVA != nil {
+			maxVA = *rate.MaxVA
+		}
+
+		if r.Method == http.MethodPost {
+			result, err := db.DB.Exec(`
+				INSERT INTO tarif_listrik (golongan, daya_min, daya_max, subsidi, tarif_per_kwh, berlaku_mulai)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				rate.Class, rate.MinVA, maxVA, rate.Subsidized, rate.PricePerKwh, rate.EffectiveFrom)
+			if err != nil {
+				log.Printf("❌ AdminTariffsHandler: Gagal menambah tarif: %v", err)
+				http.Error(w, `{"error": "Gagal menambah tarif (mungkin sudah ada untuk tanggal tersebut)"}`, http.StatusConflict)
+				return
+			}
+			id, _ := result.LastInsertId()
+			rate.ID = int(id)
+		} else {
+			if rate.ID <= 0 {
+				http.Error(w, `{"error": "ID tarif wajib diisi"}`, http.StatusBadRequest)
+				return
+			}
+			result, err := db.DB.Exec(`
+				UPDATE tarif_listrik
+				SET golongan = ?, daya_min = ?, daya_max = ?, subsidi = ?, tarif_per_kwh = ?, berlaku_mulai = ?
+				WHERE id = ?`,
+				rate.Class, rate.MinVA, maxVA, rate.Subsidized, rate.PricePerKwh, rate.EffectiveFrom, rate.ID)
+			if err != nil {
+				log.Printf("❌ AdminTariffsHandler: Gagal mengubah tarif: %v", err)
+				http.Error(w, `{"error": "Gagal mengubah tarif"}`, http.StatusInternalServerError)
+				return
+			}
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+				var exists bool
+				db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM tarif_listrik WHERE id = ?)", rate.ID).Scan(&exists)
+				if !exists {
+					http.Error(w, `{"error": "Tarif tidak ditemukan"}`, http.StatusNotFound)
+					return
+				}
+			}
+		}
+
+		invalidateTariffCache()
+		log.Printf("✅ Tarif %s %d VA (subsidi=%v) Rp %.2f/kWh berlaku %s disimpan",
+			rate.Class, rate.MinVA, rate.Subsidized, rate.PricePerKwh, rate.EffectiveFrom)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rate)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil || id <= 0 {
+			http.Error(w, `{"error": "ID tarif tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		result, err := db.DB.Exec("DELETE FROM tarif_listrik WHERE id = ?", id)
+		if err != nil {
+			log.Printf("❌ AdminTariffsHandler: Gagal menghapus tarif: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus tarif"}`, http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, `{"error": "Tarif tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+		invalidateTariffCache()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Tarif berhasil dihapus", "id": id})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTopConsumers = 10
+	maxTopConsumers     = 50
+	// measuredTolerance: selisih terukur vs perkiraan di bawah 15% dianggap sesuai
+	measuredTolerance = 15.0
+)
+
+type MeasuredComparison struct {
+	DeviceLabel  string   `json:"device_label"`
+	MeasuredKwh  float64  `json:"measured_kwh"`
+	EstimatedKwh float64  `json:"estimated_kwh"`
+	DiffPercent  *float64 `json:"diff_percent"`
+	AvgWattsOn   *float64 `json:"avg_watts_on"`
+	RatedWatts   float64  `json:"rated_watts"`
+	Verdict      string   `json:"verdict"`
+}
+
+type TopConsumer struct {
+	Rank         int                 `json:"rank"`
+	Name         string              `json:"name"`
+	Brand        string              `json:"brand"`
+	Category     string              `json:"category"`
+	Kwh          float64             `json:"kwh"`
+	Rp           float64             `json:"rp"`
+	SharePercent float64             `json:"share_percent"`
+	PreviousKwh  float64             `json:"previous_kwh"`
+	TrendPercent *float64            `json:"trend_percent"`
+	Trend        string              `json:"trend"`
+	Source       string              `json:"source"`
+	Confidence   string              `json:"confidence"`
+	Measured     *MeasuredComparison `json:"measured"`
+}
+
+type TopConsumersResponse struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	PreviousFrom string        `json:"previous_from"`
+	PreviousTo   string        `json:"previous_to"`
+	TotalKwh     float64       `json:"total_kwh"`
+	TotalRp      float64       `json:"total_rp"`
+	Source       string        `json:"source"`
+	Confidence   string        `json:"confidence"`
+	SortBy       string        `json:"sort_by"`
+	Items        []TopConsumer `json:"items"`
+}
+
+// measuredDeviceUsage: kWh dan rata-rata watt saat menyala untuk satu sensor pada [from, to).
+// kWh dijumlah per jam lewat loadSensorEnergy, jadi sensor yang di-reset di tengah periode tidak merusak totalnya.
+func measuredDeviceUsage(userID int, label string, from, to time.Time) (float64, *float64, bool) {
+	energy, err := loadSensorEnergy(userID, from, to, true)
+	if err != nil {
+		log.Printf("❌ measuredDeviceUsage: Error loading sensor energy: %v", err)
+		return 0, nil, false
+	}
+	kwh, found := 0.0, false
+	for _, e := range energy {
+		if e.Label == label {
+			kwh += e.Kwh
+			found = true
+		}
+	}
+	if !found {
+		return 0, nil, false
+	}
+
+	var avgWatts sql.NullFloat64
+	if err := db.DB.QueryRow(`
+		SELECT AVG(CASE WHEN watt >= 0.1 THEN watt END)
+		FROM energy_logs
+		WHERE user_id = ? AND device_label = ? AND created_at >= ? AND created_at < ?`,
+		userID, label, from, to).Scan(&avgWatts); err != nil {
+		log.Printf("⚠️ measuredDeviceUsage: Error querying average watts: %v", err)
+	}
+	var avg *float64
+	if avgWatts.Valid {
+		value := roundTo(avgWatts.Float64, 1)
+		avg = &value
+	}
+	return kwh, avg, true
+}
+
+func measuredVerdict(diffPercent *float64) string {
+	switch {
+	case diffPercent == nil:
+		return "no_estimate"
+	case *diffPercent > measuredTolerance:
+		return "higher_than_rated"
+	case *diffPercent < -measuredTolerance:
+		return "lower_than_rated"
+	default:
+		return "as_rated"
+	}
+}
+
+// TopConsumersHandler: peringkat perangkat berdasarkan kWh atau Rp pada suatu periode.
+// Query: from, to (YYYY-MM-DD, default 30 hari terakhir), sort=kwh|rp, limit (default 10).
+// Tren dibandingkan dengan periode sebelumnya yang sama panjang.
+func TopConsumersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := parseSeriesRange(query.Get("from"), query.Get("to"), time.Local)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "rp"
+	}
+	if sortBy != "kwh" && sortBy != "rp" {
+		http.Error(w, `{"error": "sort harus kwh atau rp"}`, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTopConsumers
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error": "limit harus angka positif"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxTopConsumers)
+	}
+
+	records, err := loadApplianceRecords(userID)
+	if err != nil {
+		log.Printf("❌ TopConsumersHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	links, err := loadIotLinks(userID)
+	if err != nil {
+		log.Printf("⚠️ TopConsumersHandler: Gagal memuat tautan sensor: %v", err)
+	}
+	profile := userBillingProfile(userID)
+
+	days := int(to.Sub(from).Hours()/24 + 0.5)
+	prevFrom := from.AddDate(0, 0, -days)
+	current := collectPeriodUsage(records, loadMeasuredUsage(userID, from, to, time.Local), profile, from, to)
+	previous := collectPeriodUsage(records, loadMeasuredUsage(userID, prevFrom, from, time.Local), profile, prevFrom, from)
+	// Pembanding terukur vs rating butuh perkiraan murni (tanpa data sensor)
+	var estimated periodUsage
+	if len(links) > 0 {
+		estimated = collectPeriodUsage(records, measuredUsage{}, profile, from, to)
+	}
+
+	response := TopConsumersResponse{
+		From:         from.Format("2006-01-02"),
+		To:           to.AddDate(0, 0, -1).Format("2006-01-02"),
+		PreviousFrom: prevFrom.Format("2006-01-02"),
+		PreviousTo:   from.AddDate(0, 0, -1).Format("2006-01-02"),
+		TotalKwh:     roundTo(current.Kwh, 2),
+		TotalRp:      roundTo(current.Rp, 0),
+		SortBy:       sortBy,
+		Items:        []TopConsumer{},
+	}
+
+	response.Source, response.Confidence = current.label()
+
+	for key, rec := range current.ApplianceInfo {
+		usage := current.Appliances[key]
+		kwh := usage.Kwh
+		if kwh <= 0 {
+			continue
+		}
+		item := TopConsumer{
+			Name:         rec.Name,
+			Brand:        rec.Brand,
+			Category:     rec.CategoryName,
+			Kwh:          roundTo(kwh, 2),
+			Rp:           roundTo(usage.Rp, 0),
+			PreviousKwh:  roundTo(previous.Appliances[key].Kwh, 2),
+			TrendPercent: percentChange(kwh, previous.Appliances[key].Kwh),
+			Trend:        comparisonStatus(kwh, previous.Appliances[key].Kwh),
+		}
+		item.Source, item.Confidence = usage.label()
+		if item.Category == "" {
+			item.Category = uncategorizedLabel
+		}
+		if current.Kwh > 0 {
+			item.SharePercent = roundTo(kwh/current.Kwh*100, 1)
+		}
+
+		if label, linked := links[key]; linked {
+			if measuredKwh, avgWatts, found := measuredDeviceUsage(userID, label, from, to); found {
+				estimatedKwh := estimated.Appliances[key].Kwh
+				diff := percentChange(measuredKwh, estimatedKwh)
+				item.Measured = &MeasuredComparison{
+					DeviceLabel:  label,
+					MeasuredKwh:  roundTo(measuredKwh, 2),
+					EstimatedKwh: roundTo(estimatedKwh, 2),
+					DiffPercent:  diff,
+					AvgWattsOn:   avgWatts,
+					RatedWatts:   rec.Power * float64(rec.Quantity),
+					Verdict:      measuredVerdict(diff),
+				}
+			}
+		}
+		response.Items = append(response.Items, item)
+	}
+
+	sort.Slice(response.Items, func(i, j int) bool {
+		a, b := response.Items[i], response.Items[j]
+		if sortBy == "kwh" && a.Kwh != b.Kwh {
+			return a.Kwh > b.Kwh
+		}
+		if a.Rp != b.Rp {
+			return a.Rp > b.Rp
+		}
+		return a.Name < b.Name
+	})
+	if len(response.Items) > limit {
+		response.Items = response.Items[:limit]
+	}
+	for i := range response.Items {
+		response.Items[i].Rank = i + 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
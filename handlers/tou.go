@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TouTariff: definisi tarif waktu pemakaian (WBP = Waktu Beban Puncak, LWBP = Luar WBP)
+type TouTariff struct {
+	ID            int     `json:"id"`
+	MinVA         int     `json:"daya_min"`
+	MaxVA         *int    `json:"daya_max"`
+	PeakStart     string  `json:"wbp_mulai"`
+	PeakEnd       string  `json:"wbp_selesai"`
+	PeakRate      float64 `json:"tarif_wbp"`
+	OffPeakRate   float64 `json:"tarif_lwbp"`
+	EffectiveFrom string  `json:"berlaku_mulai"`
+}
+
+type TouUsage struct {
+	PeakKwh     float64 `json:"wbp_kwh"`
+	OffPeakKwh  float64 `json:"lwbp_kwh"`
+	TouCost     float64 `json:"tou_cost"`
+	FlatCost    float64 `json:"flat_cost"`
+	PeakSharePc float64 `json:"wbp_share_percent"`
+}
+
+type ShiftSaving struct {
+	ID             int     `json:"id"`
+	Name           string  `json:"name"`
+	PeakKwh        float64 `json:"wbp_kwh"`
+	MonthlySavings float64 `json:"monthly_savings"`
+	Scheduled      bool    `json:"scheduled"`
+}
+
+type TouAnalysisResponse struct {
+	BesarListrik      string        `json:"besar_listrik"`
+	Applicable        bool          `json:"applicable"`
+	Tariff            TouTariff     `json:"tariff"`
+	Estimated         TouUsage      `json:"estimated_monthly"`
+	Measured          *TouUsage     `json:"measured_last_30_days"`
+	ShiftSavings      []ShiftSaving `json:"shift_savings"`
+	TotalShiftSavings float64       `json:"total_shift_savings"`
+}
+
+// touTariffCache: definisi WBP/LWBP di memori, sama seperti tariffCache (TTL tariffCacheTTL, di-reset saat admin mengubah)
+var touTariffCache struct {
+	sync.RWMutex
+	tariffs  []TouTariff
+	loadedAt time.Time
+}
+
+func loadTouTariffs() ([]TouTariff, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, daya_min, daya_max, TIME_FORMAT(wbp_mulai, '%H:%i'), TIME_FORMAT(wbp_selesai, '%H:%i'),
+		       tarif_wbp, tarif_lwbp, berlaku_mulai
+		FROM tarif_wbp ORDER BY daya_min, berlaku_mulai`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tariffs := []TouTariff{}
+	for rows.Next() {
+		var tariff TouTariff
+		var maxVA sql.NullInt64
+		var effectiveFrom time.Time
+		if err := rows.Scan(&tariff.ID, &tariff.MinVA, &maxVA, &tariff.PeakStart, &tariff.PeakEnd,
+			&tariff.PeakRate, &tariff.OffPeakRate, &effectiveFrom); err != nil {
+			return nil, err
+		}
+		if maxVA.Valid {
+			max := int(maxVA.Int64)
+			tariff.MaxVA = &max
+		}
+		tariff.EffectiveFrom = effectiveFrom.Format("2006-01-02")
+		tariffs = append(tariffs, tariff)
+	}
+	return tariffs, rows.Err()
+}
+
+func cachedTouTariffs() []TouTariff {
+	touTariffCache.RLock()
+	tariffs, fresh := touTariffCache.tariffs, time.Since(touTariffCache.loadedAt) < tariffCacheTTL
+	touTariffCache.RUnlock()
+	if fresh {
+		return tariffs
+	}
+
+	loaded, err := loadTouTariffs()
+	if err != nil {
+		log.Printf("❌ Gagal memuat tarif WBP, pakai cache lama: %v", err)
+		return tariffs
+	}
+	touTariffCache.Lock()
+	touTariffCache.tariffs = loaded
+	touTariffCache.loadedAt = time.Now()
+	touTariffCache.Unlock()
+	return loaded
+}
+
+func invalidateTouTariffCache() {
+	touTariffCache.Lock()
+	touTariffCache.loadedAt = time.Time{}
+	touTariffCache.Unlock()
+}
+
+// findTouTariff mencari definisi WBP/LWBP untuk daya rumah. Kalau tidak ada yang cocok,
+// definisi dengan daya terkecil dipakai sebagai simulasi (applicable=false).
+func findTouTariff(besarListrik string, now time.Time) (TouTariff, bool, bool) {
+	tariffs := cachedTouTariffs()
+	va, _ := parseCapacityVA(besarListrik)
+	day := now.Format("2006-01-02")
+
+	var best, simulated *TouTariff
+	for i := range tariffs {
+		tariff := &tariffs[i]
+		if tariff.EffectiveFrom > day {
+			continue
+		}
+		if simulated == nil || tariff.MinVA < simulated.MinVA {
+			simulated = tariff
+		}
+		if va >= tariff.MinVA && (tariff.MaxVA == nil || va <= *tariff.MaxVA) {
+			if best == nil || tariff.EffectiveFrom > best.EffectiveFrom {
+				best = tariff
+			}
+		}
+	}
+	if best != nil {
+		return *best, true, true
+	}
+	if simulated != nil {
+		return *simulated, false, true
+	}
+	return TouTariff{}, false, false
+}
+
+// peakBounds: menit mulai/selesai WBP; kalau lewat tengah malam, selesai ditambah 1440
+func (tariff TouTariff) peakBounds() (int, int) {
+	start, _ := parseClock(tariff.PeakStart)
+	end, _ := parseClock(tariff.PeakEnd)
+	if end <= start {
+		end += minutesPerDay
+	}
+	return start, end
+}
+
+func (tariff TouTariff) peakMinutesPerDay() int {
+	start, end := tariff.peakBounds()
+	return end - start
+}
+
+// peakOverlapMinutes: berapa menit dari rentang [start, end) (menit sejak awal hari) yang jatuh di WBP
+func (tariff TouTariff) peakOverlapMinutes(start, end int) int {
+	peakStart, peakEnd := tariff.peakBounds()
+	overlap := 0
+	for _, offset := range []int{-minutesPerDay, 0, minutesPerDay} {
+		lo := max(start, peakStart+offset)
+		hi := min(end, peakEnd+offset)
+		if hi > lo {
+			overlap += hi - lo
+		}
+	}
+	return overlap
+}
+
+// weeklyPeakSplit: kWh per minggu di WBP dan LWBP untuk satu perangkat.
+// Perangkat tanpa jadwal dianggap menyala merata sepanjang hari.
+func weeklyPeakSplit(rec applianceRecord, tariff TouTariff) (float64, float64) {
+	kw := rec.Power * float64(rec.Quantity) / 1000.0
+	if len(rec.Schedule) == 0 {
+		weeklyKwh := kw * rec.Duration * 7
+		peakShare := float64(tariff.peakMinutesPerDay()) / minutesPerDay
+		return weeklyKwh * peakShare, weeklyKwh * (1 - peakShare)
+	}
+
+	peakMinutes, totalMinutes := 0, 0
+	for _, window := range rec.Schedule {
+		start, end, err := windowBounds(window)
+		if err != nil {
+			continue
+		}
+		totalMinutes += end - start
+		peakMinutes += tariff.peakOverlapMinutes(start, end)
+	}
+	return kw * float64(peakMinutes) / 60, kw * float64(totalMinutes-peakMinutes) / 60
+}
+
+func (tariff TouTariff) usage(peakKwh, offPeakKwh, flatRate float64) TouUsage {
+	usage := TouUsage{
+		PeakKwh:    roundTo(peakKwh, 2),
+		OffPeakKwh: roundTo(offPeakKwh, 2),
+		TouCost:    roundTo(peakKwh*tariff.PeakRate+offPeakKwh*tariff.OffPeakRate, 0),
+		FlatCost:   roundTo((peakKwh+offPeakKwh)*flatRate, 0),
+	}
+	if total := peakKwh + offPeakKwh; total > 0 {
+		usage.PeakSharePc = roundTo(peakKwh/total*100, 1)
+	}
+	return usage
+}
+
+// measuredPeakSplit menghitung kWh meter utama di WBP dan LWBP sejak tanggal tertentu.
+// Kenaikan kwh_total dihitung per sensor per jam; jamnya diambil dari pembacaan pertama di zona waktu since,
+// bukan dari jam SQL (energy_logs tersimpan dalam UTC), lalu dicocokkan dengan jam WBP.
+func measuredPeakSplit(userID int, since time.Time, tariff TouTariff) (float64, float64, bool) {
+	energy, err := houseEnergy(userID, since, time.Now(), true)
+	if err != nil {
+		log.Printf("❌ measuredPeakSplit: Error querying energy_logs: %v", err)
+		return 0, 0, false
+	}
+
+	peakKwh, offPeakKwh := 0.0, 0.0
+	for _, e := range energy {
+		hour := e.First.In(since.Location()).Hour()
+		if tariff.peakOverlapMinutes(hour*60, hour*60+60) >= 30 {
+			peakKwh += e.Kwh
+		} else {
+			offPeakKwh += e.Kwh
+		}
+	}
+	return peakKwh, offPeakKwh, len(energy) > 0
+}
+
+// TouAnalysisHandler membandingkan biaya tarif flat vs WBP/LWBP dan potensi hemat kalau beban digeser
+func TouAnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	records, err := latestSubmissionRecords(userID)
+	if err != nil {
+		log.Printf("❌ TouAnalysisHandler: Error loading records: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, `{"error": "Belum ada data perangkat"}`, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	besarListrik := records[0].BesarListrik
+	tariff, applicable, found := findTouTariff(besarListrik, now)
+	if !found {
+		http.Error(w, `{"error": "Belum ada definisi tarif WBP/LWBP"}`, http.StatusNotFound)
+		return
+	}
+	flatRate := tariffPerKwhAt(besarListrik, userSubsidized(userID), now)
+
+	const weeksPerMonth = 30.0 / 7.0
+	response := TouAnalysisResponse{BesarListrik: besarListrik, Applicable: applicable, Tariff: tariff, ShiftSavings: []ShiftSaving{}}
+	totalPeak, totalOffPeak := 0.0, 0.0
+	for _, rec := range records {
+		peakKwh, offPeakKwh := weeklyPeakSplit(rec, tariff)
+		peakKwh, offPeakKwh = peakKwh*weeksPerMonth, offPeakKwh*weeksPerMonth
+		totalPeak += peakKwh
+		totalOffPeak += offPeakKwh
+
+		savings := peakKwh * (tariff.PeakRate - tariff.OffPeakRate)
+		if savings > 0 {
+			response.ShiftSavings = append(response.ShiftSavings, ShiftSaving{
+				ID: rec.ID, Name: rec.Name, PeakKwh: roundTo(peakKwh, 2),
+				MonthlySavings: roundTo(savings, 0), Scheduled: len(rec.Schedule) > 0,
+			})
+			response.TotalShiftSavings += roundTo(savings, 0)
+		}
+	}
+	response.Estimated = tariff.usage(totalPeak, totalOffPeak, flatRate)
+	sort.Slice(response.ShiftSavings, func(i, j int) bool {
+		return response.ShiftSavings[i].MonthlySavings > response.ShiftSavings[j].MonthlySavings
+	})
+
+	if peakKwh, offPeakKwh, ok := measuredPeakSplit(userID, now.AddDate(0, 0, -30), tariff); ok {
+		measured := tariff.usage(peakKwh, offPeakKwh, flatRate)
+		response.Measured = &measured
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AdminTouTariffsHandler: GET daftar definisi WBP/LWBP, POST tambah, DELETE ?id= hapus. Wajib X-Admin-Token.
+func AdminTouTariffsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tariffs, err := loadTouTariffs()
+		if err != nil {
+			log.Printf("❌ AdminTouTariffsHandler: Error loading tariffs: %v", err)
+			http.Error(w, `{"error": "Gagal mengambil data tarif WBP"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tariffs)
+
+	case http.MethodPost:
+		var tariff TouTariff
+		if err := json.NewDecoder(r.Body).Decode(&tariff); err != nil {
+			http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		_, errStart := parseClock(tariff.PeakStart)
+		_, errEnd := parseClock(tariff.PeakEnd)
+		if errStart != nil || errEnd != nil || tariff.PeakStart == tariff.PeakEnd {
+			http.Error(w, `{"error": "Jam WBP tidak valid (format HH:MM)"}`, http.StatusBadRequest)
+			return
+		}
+		if tariff.PeakRate <= 0 || tariff.OffPeakRate <= 0 || tariff.MinVA < 0 ||
+			(tariff.MaxVA != nil && *tariff.MaxVA < tariff.MinVA) {
+			http.Error(w, `{"error": "Tarif atau rentang daya tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		if _, err := time.Parse("2006-01-02", tariff.EffectiveFrom); err != nil {
+			http.Error(w, `{"error": "Format berlaku_mulai harus YYYY-MM-DD"}`, http.StatusBadRequest)
+			return
+		}
+
+		var maxVA interface{}
+		if tariff.MaxVA != nil {
+			maxVA = *tariff.MaxVA
+		}
+		result, err := db.DB.Exec(`
+			INSERT INTO tarif_wbp (daya_min, daya_max, wbp_mulai, wbp_selesai, tarif_wbp, tarif_lwbp, berlaku_mulai)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			tariff.MinVA, maxVA, tariff.PeakStart, tariff.PeakEnd, tariff.PeakRate, tariff.OffPeakRate, tariff.EffectiveFrom)
+		if err != nil {
+			log.Printf("❌ AdminTouTariffsHandler: Gagal menyimpan tarif WBP: %v", err)
+			http.Error(w, `{"error": "Gagal menyimpan tarif WBP"}`, http.StatusInternalServerError)
+			return
+		}
+		id, _ := result.LastInsertId()
+		tariff.ID = int(id)
+		invalidateTouTariffCache()
+		log.Printf("✅ Tarif WBP %s-%s (Rp %.2f / Rp %.2f) disimpan", tariff.PeakStart, tariff.PeakEnd, tariff.PeakRate, tariff.OffPeakRate)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tariff)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil || id <= 0 {
+			http.Error(w, `{"error": "ID tarif tidak valid"}`, http.StatusBadRequest)
+			return
+		}
+		result, err := db.DB.Exec("DELETE FROM tarif_wbp WHERE id = ?", id)
+		if err != nil {
+			log.Printf("❌ AdminTouTariffsHandler: Gagal menghapus tarif WBP: %v", err)
+			http.Error(w, `{"error": "Gagal menghapus tarif WBP"}`, http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, `{"error": "Tarif tidak ditemukan"}`, http.StatusNotFound)
+			return
+		}
+		invalidateTouTariffCache()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": fmt.Sprintf("Tarif WBP %d dihapus", id), "id": id})
+
+	default:
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+	}
+}
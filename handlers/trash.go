@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TrashRetentionDays: berapa lama perangkat yang dihapus disimpan di sampah sebelum dipurge
+const TrashRetentionDays = 30
+
+type TrashItemResponse struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"nama_perangkat"`
+	Brand     string  `json:"brand"`
+	Power     float64 `json:"daya"`
+	Usage     float64 `json:"durasi"`
+	Quantity  int     `json:"jumlah"`
+	InputDate string  `json:"tanggal_input"`
+	DeletedAt string  `json:"deleted_at"`
+	PurgeAt   string  `json:"purge_at"`
+}
+
+// TrashHandler menampilkan perangkat yang ada di sampah (terbaru dihapus paling atas)
+func TrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, nama_perangkat, merek, daya, durasi, COALESCE(jumlah, 1), tanggal_input, deleted_at
+		FROM riwayat_perangkat
+		WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC`, userID)
+	if err != nil {
+		log.Printf("❌ TrashHandler: Error querying trash: %v", err)
+		http.Error(w, `{"error": "Gagal mengambil data sampah"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []TrashItemResponse{}
+	for rows.Next() {
+		var item TrashItemResponse
+		var inputDate, deletedAt time.Time
+		if err := rows.Scan(&item.ID, &item.Name, &item.Brand, &item.Power, &item.Usage, &item.Quantity,
+			&inputDate, &deletedAt); err != nil {
+			log.Printf("❌ TrashHandler: Error scanning row: %v", err)
+			http.Error(w, `{"error": "Gagal membaca data sampah"}`, http.StatusInternalServerError)
+			return
+		}
+		item.InputDate = inputDate.Format("2006-01-02")
+		item.DeletedAt = deletedAt.Format(time.RFC3339)
+		item.PurgeAt = deletedAt.AddDate(0, 0, TrashRetentionDays).Format(time.RFC3339)
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// RestoreApplianceHandler mengembalikan perangkat dari sampah (undo hapus)
+func RestoreApplianceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Metode tidak diizinkan"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := Store.Get(r, "elektronik_rumah_session")
+	if err != nil {
+		http.Error(w, `{"error": "Gagal mendapatkan sesi"}`, http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		http.Error(w, `{"error": "Tidak terautentikasi"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var requestData struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil || requestData.ID <= 0 {
+		http.Error(w, `{"error": "Data tidak valid"}`, http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.DB.Exec(`
+		UPDATE riwayat_perangkat SET deleted_at = NULL
+		WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL`, requestData.ID, userID)
+	if err != nil {
+		log.Printf("❌ RestoreApplianceHandler: Gagal restore perangkat: %v", err)
+		http.Error(w, `{"error": "Gagal mengembalikan perangkat"}`, http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, `{"error": "Perangkat tidak ada di sampah"}`, http.StatusNotFound)
+		return
+	}
+
+	log.Printf("✅ Perangkat %d milik user %d dikembalikan dari sampah", requestData.ID, userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Perangkat berhasil dikembalikan",
+		"id":      requestData.ID,
+	})
+}
+
+// purgeTrash menghapus permanen isi sampah yang lebih lama dari masa simpan.
+// Jadwal, tautan sensor, dan baris riwayat diproses dalam satu transaksi.
+func purgeTrash() {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("❌ [PURGE] Gagal memulai transaksi: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Jadwal ikut dihapus supaya tidak jadi data yatim
+	_, err = tx.Exec(`
+		DELETE j FROM jadwal_perangkat j
+		JOIN riwayat_perangkat rp ON rp.id = j.riwayat_id
+		WHERE rp.deleted_at IS NOT NULL AND rp.deleted_at < NOW() - INTERVAL ? DAY`, TrashRetentionDays)
+	if err != nil {
+		log.Printf("❌ [PURGE] Gagal menghapus jadwal perangkat: %v", err)
+		return
+	}
+
+	// Sensor yang ditautkan lewat baris yang dipurge dipindah ke baris aktif terbaru dengan nama + merek
+	// yang sama; kalau tidak ada, tautannya dilepas (bukan hilang diam-diam karena barisnya terhapus)
+	if err := relinkPurgedSensors(tx); err != nil {
+		log.Printf("❌ [PURGE] Gagal memindahkan tautan sensor: %v", err)
+		return
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM riwayat_perangkat
+		WHERE deleted_at IS NOT NULL AND deleted_at < NOW() - INTERVAL ? DAY`, TrashRetentionDays)
+	if err != nil {
+		log.Printf("❌ [PURGE] Gagal purge sampah: %v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("❌ [PURGE] Gagal commit purge: %v", err)
+		return
+	}
+	if purged, _ := result.RowsAffected(); purged > 0 {
+		log.Printf("🧹 [PURGE] %d perangkat dihapus permanen dari sampah", purged)
+	}
+}
+
+// relinkPurgedSensors menautkan ulang sensor yang riwayat_id-nya akan dipurge
+func relinkPurgedSensors(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT pi.id, pi.user_id, rp.nama_perangkat, COALESCE(rp.merek, '')
+		FROM perangkat_iot pi
+		JOIN riwayat_perangkat rp ON rp.id = pi.riwayat_id
+		WHERE rp.deleted_at IS NOT NULL AND rp.deleted_at < NOW() - INTERVAL ? DAY`, TrashRetentionDays)
+	if err != nil {
+		return err
+	}
+	type purgedLink struct {
+		ID, UserID  int
+		Name, Brand string
+	}
+	var links []purgedLink
+	for rows.Next() {
+		var link purgedLink
+		if err := rows.Scan(&link.ID, &link.UserID, &link.Name, &link.Brand); err != nil {
+			rows.Close()
+			return err
+		}
+		links = append(links, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		var newID sql.NullInt64
+		err := tx.QueryRow(`
+			SELECT id FROM riwayat_perangkat
+			WHERE user_id = ? AND deleted_at IS NULL
+				AND LOWER(TRIM(nama_perangkat)) = LOWER(TRIM(?)) AND LOWER(TRIM(COALESCE(merek, ''))) = LOWER(TRIM(?))
+			ORDER BY tanggal_input DESC, id DESC LIMIT 1`, link.UserID, link.Name, link.Brand).Scan(&newID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		var riwayatID interface{}
+		if newID.Valid {
+			riwayatID = newID.Int64
+		}
+		if _, err := tx.Exec(`UPDATE perangkat_iot SET riwayat_id = ? WHERE id = ?`, riwayatID, link.ID); err != nil {
+			return err
+		}
+		if !newID.Valid {
+			log.Printf("ℹ️ [PURGE] Tautan sensor %d milik user %d dilepas, perangkat %s tidak ada lagi", link.ID, link.UserID, link.Name)
+		}
+	}
+	return nil
+}
+
+// StartTrashPurgeScheduler menjalankan purge sekali saat start lalu setiap interval
+func StartTrashPurgeScheduler(interval time.Duration) {
+	go func() {
+		log.Printf("⏰ Purge sampah dimulai, retensi %d hari, cek setiap %v...", TrashRetentionDays, interval)
+		purgeTrash()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeTrash()
+		}
+	}()
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"EnerTrack-BE/db"
+	"log"
+	"time"
+)
+
+// submissionBatch: satu batch submit (id_submit) dan tanggal mulai berlakunya
+type submissionBatch struct {
+	IDSubmit string
+	Start    string // YYYY-MM-DD
+	Records  []applianceRecord
+}
+
+// inventoryTimeline menyusun batch submit berurutan dari yang terlama.
+// Asumsinya: batch submit terbaru menggantikan inventaris sebelumnya mulai tanggal submitnya.
+type inventoryTimeline []submissionBatch
+
+// buildInventoryTimeline mengelompokkan records (sudah urut tanggal_input, id) per id_submit
+func buildInventoryTimeline(records []applianceRecord) inventoryTimeline {
+	var timeline inventoryTimeline
+	index := make(map[string]int)
+	for _, rec := range records {
+		i, exists := index[rec.IDSubmit]
+		if !exists {
+			i = len(timeline)
+			index[rec.IDSubmit] = i
+			timeline = append(timeline, submissionBatch{IDSubmit: rec.IDSubmit, Start: rec.InputDate.Format("2006-01-02")})
+		}
+		timeline[i].Records = append(timeline[i].Records, rec)
+	}
+	return timeline
+}
+
+// activeOn mengembalikan perangkat yang dipakai pada hari tertentu: batch terakhir yang sudah mulai
+// pada hari itu, tanpa baris yang baru ditambahkan sesudahnya. Kalau backfill=true dan hari itu
+// sebelum submit pertama, batch pertama dianggap sudah berlaku (untuk membandingkan tagihan lama).
+func (timeline inventoryTimeline) activeOn(day time.Time, backfill bool) (submissionBatch, bool) {
+	dayKey := day.Format("2006-01-02")
+	for i := len(timeline) - 1; i >= 0; i-- {
+		batch := timeline[i]
+		if batch.Start > dayKey {
+			continue
+		}
+		active := submissionBatch{IDSubmit: batch.IDSubmit, Start: batch.Start}
+		for _, rec := range batch.Records {
+			if rec.InputDate.Format("2006-01-02") <= dayKey {
+				active.Records = append(active.Records, rec)
+			}
+		}
+		return active, true
+	}
+	if backfill && len(timeline) > 0 {
+		return timeline[0], true
+	}
+	return submissionBatch{}, false
+}
+
+// estimatedKWhOn: total kWh perkiraan pada hari tertentu (mengikuti jadwal per hari)
+func (batch submissionBatch) estimatedKWhOn(day time.Time) float64 {
+	total := 0.0
+	for _, rec := range batch.Records {
+		total += rec.dailyKWhOn(day.Weekday())
+	}
+	return total
+}
+
+// hourlyKWhOn: sebaran kWh per jam pada hari tertentu. Rentang jadwal yang lewat tengah malam
+// tetap dihitung di hari mulainya (sama dengan dailyUsageHours); perangkat tanpa jadwal disebar rata 24 jam.
+func (rec applianceRecord) hourlyKWhOn(day time.Time) [24]float64 {
+	var hourly [24]float64
+	if len(rec.Schedule) == 0 {
+		perHour := rec.dailyKWhOn(day.Weekday()) / 24
+		for hour := range hourly {
+			hourly[hour] = perHour
+		}
+		return hourly
+	}
+	kwPerMinute := rec.Power * float64(rec.Quantity) / 1000.0 / 60
+	for _, window := range rec.Schedule {
+		if window.Weekday != int(day.Weekday()) {
+			continue
+		}
+		start, end, err := windowBounds(window)
+		if err != nil {
+			continue
+		}
+		for minute := start; minute < end; minute++ {
+			hourly[(minute%minutesPerDay)/60] += kwPerMinute
+		}
+	}
+	return hourly
+}
+
+// measuredUsage: kWh terukur per jam (key = Unix awal jam). Sensor bertanda meter utama mengukur
+// seluruh rumah (Main); sensor yang ditautkan dikelompokkan per identityKey perangkat; sensor tanpa
+// tautan yang ditempatkan di ruangan jadi perangkat semu di ruangan itu (Placed). Sensor lain diabaikan.
+type measuredUsage struct {
+	Main   map[int64]float64
+	Linked map[string]map[int64]float64
+	Placed map[string]placedSensor // key = placedSensorKey(label)
+}
+
+// placedSensor: pemakaian per jam sensor ruangan beserta perangkat semunya
+type placedSensor struct {
+	Record applianceRecord
+	Hours  map[int64]float64
+}
+
+// placedSensorKey dibedakan dari identityKey (yang selalu memuat "|") supaya tidak bentrok dengan perangkat
+func placedSensorKey(label string) string {
+	return "iot:" + label
+}
+
+// sensorEnergy: kWh satu sensor dalam satu kelompok pembacaan, beserta waktu pembacaan pertama dan terakhir
+type sensorEnergy struct {
+	Label       string
+	First, Last time.Time
+	Kwh         float64
+}
+
+// loadSensorEnergy adalah satu-satunya agregasi kWh dari energy_logs (kwh_total kumulatif per sensor) pada [from, to).
+// perHour=true mengelompokkan per jam SQL; pemanggil menghitung ulang jamnya dari First.In(loc).
+// Kalau false, satu baris per sensor untuk seluruh rentang. kWh negatif (sensor di-reset) dibulatkan ke 0.
+func loadSensorEnergy(userID int, from, to time.Time, perHour bool) ([]sensorEnergy, error) {
+	groupBy := "device_label"
+	if perHour {
+		groupBy = "device_label, DATE_FORMAT(created_at, '%Y-%m-%d %H')"
+	}
+	rows, err := db.DB.Query(`
+		SELECT device_label, MIN(created_at), MAX(created_at), MAX(kwh_total) - MIN(kwh_total)
+		FROM energy_logs
+		WHERE user_id = ? AND created_at >= ? AND created_at < ? AND kwh_total IS NOT NULL
+		GROUP BY `+groupBy, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []sensorEnergy
+	for rows.Next() {
+		var e sensorEnergy
+		if err := rows.Scan(&e.Label, &e.First, &e.Last, &e.Kwh); err != nil {
+			return nil, err
+		}
+		e.Kwh = max(e.Kwh, 0)
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// houseEnergy: seperti loadSensorEnergy tapi hanya meter utama, jadi sensor yang ditautkan ke perangkat
+// (atau sensor lain yang belum diatur) tidak ikut terhitung sebagai konsumsi seluruh rumah
+func houseEnergy(userID int, from, to time.Time, perHour bool) ([]sensorEnergy, error) {
+	roles, err := loadSensorRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles.Main) == 0 {
+		return nil, nil
+	}
+	all, err := loadSensorEnergy(userID, from, to, perHour)
+	if err != nil {
+		return nil, err
+	}
+	var result []sensorEnergy
+	for _, e := range all {
+		if roles.Main[e.Label] {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// loadMeasuredUsage mengambil kWh per sensor per jam pada [from, to), jam dihitung di zona waktu loc.
+// Energi di antara pembacaan terakhir satu jam dan pembacaan pertama jam berikutnya tidak ikut terhitung.
+func loadMeasuredUsage(userID int, from, to time.Time, loc *time.Location) measuredUsage {
+	usage := measuredUsage{
+		Main:   make(map[int64]float64),
+		Linked: make(map[string]map[int64]float64),
+		Placed: make(map[string]placedSensor),
+	}
+
+	roles, err := loadSensorRoles(userID)
+	if err != nil {
+		log.Printf("⚠️ loadMeasuredUsage: Gagal memuat peran sensor: %v", err)
+	}
+	if len(roles.Main) == 0 && len(roles.Linked) == 0 && len(roles.Placed) == 0 {
+		return usage
+	}
+
+	energy, err := loadSensorEnergy(userID, from, to, true)
+	if err != nil {
+		log.Printf("❌ loadMeasuredUsage: Error querying energy_logs: %v", err)
+		return usage
+	}
+	for _, e := range energy {
+		hour := bucketStart(e.First.In(loc), "hour").Unix()
+		if key, linked := roles.Linked[e.Label]; linked {
+			if usage.Linked[key] == nil {
+				usage.Linked[key] = make(map[int64]float64)
+			}
+			usage.Linked[key][hour] += e.Kwh
+			continue
+		}
+		if rec, placed := roles.Placed[e.Label]; placed {
+			key := placedSensorKey(e.Label)
+			sensor, exists := usage.Placed[key]
+			if !exists {
+				sensor = placedSensor{Record: rec, Hours: make(map[int64]float64)}
+				usage.Placed[key] = sensor
+			}
+			sensor.Hours[hour] += e.Kwh
+			continue
+		}
+		if roles.Main[e.Label] {
+			usage.Main[hour] += e.Kwh
+		}
+	}
+	return usage
+}
+
+// measuredKWhBetween menjumlahkan kWh meter utama dalam rentang [from, to).
+// ok=false kalau tidak ada data meter utama sama sekali.
+func measuredKWhBetween(userID int, from, to time.Time) (float64, bool) {
+	energy, err := houseEnergy(userID, from, to, false)
+	if err != nil {
+		log.Printf("❌ measuredKWhBetween: Error querying energy_logs: %v", err)
+		return 0, false
+	}
+	total := 0.0
+	for _, e := range energy {
+		total += e.Kwh
+	}
+	return total, len(energy) > 0
+}
@@ -11,6 +11,12 @@ import (
 type UpdateProfileRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	// Opsional: wilayah untuk perhitungan PPJ/admin di tagihan (lihat GET /regions)
+	Region *string `json:"kode_wilayah"`
+	// Opsional: jumlah penghuni rumah untuk benchmark (lihat GET /benchmark); 0 berarti dikosongkan
+	Occupants *int `json:"jumlah_penghuni"`
+	// Opsional: pelanggan bersubsidi, tarif subsidi dipakai di semua perhitungan biaya
+	Subsidized *bool `json:"subsidi"`
 }
 
 // UpdateUserProfileHandler menangani pembaruan data profil pengguna
@@ -43,6 +49,18 @@ func UpdateUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Region != nil && *req.Region != "" {
+		if _, exists := loadRegionFees(*req.Region); !exists {
+			http.Error(w, `{"error": "Kode wilayah tidak dikenal"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Occupants != nil && (*req.Occupants < 0 || *req.Occupants > 50) {
+		http.Error(w, `{"error": "Jumlah penghuni harus antara 1 dan 50"}`, http.StatusBadRequest)
+		return
+	}
+
 	// Update kolom 'username' dengan nilai username yang baru
 	query := "UPDATE users SET username = ?,  email = ? WHERE user_id = ?"
 	result, err := db.DB.Exec(query, req.Username, req.Email, userID)
@@ -53,6 +71,38 @@ func UpdateUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Region != nil {
+		var region interface{}
+		if *req.Region != "" {
+			region = *req.Region
+		}
+		if _, err := db.DB.Exec("UPDATE users SET kode_wilayah = ? WHERE user_id = ?", region, userID); err != nil {
+			log.Printf("❌ Gagal mengupdate wilayah untuk user_id %d: %v", userID, err)
+			http.Error(w, `{"error": "Gagal memperbarui wilayah"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Occupants != nil {
+		var occupants interface{}
+		if *req.Occupants > 0 {
+			occupants = *req.Occupants
+		}
+		if _, err := db.DB.Exec("UPDATE users SET jumlah_penghuni = ? WHERE user_id = ?", occupants, userID); err != nil {
+			log.Printf("❌ Gagal mengupdate jumlah penghuni untuk user_id %d: %v", userID, err)
+			http.Error(w, `{"error": "Gagal memperbarui jumlah penghuni"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Subsidized != nil {
+		if _, err := db.DB.Exec("UPDATE users SET subsidi = ? WHERE user_id = ?", *req.Subsidized, userID); err != nil {
+			log.Printf("❌ Gagal mengupdate status subsidi untuk user_id %d: %v", userID, err)
+			http.Error(w, `{"error": "Gagal memperbarui status subsidi"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
 		http.Error(w, `{"error": "User tidak ditemukan"}`, http.StatusNotFound)
@@ -33,7 +33,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Idempotency-Key, X-Admin-Token")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		log.Printf("Incoming Request: %s %s", r.Method, r.URL.Path)
@@ -127,9 +127,24 @@ func main() {
 	if app != nil {
 		// Panggil dengan parameter lengkap (karena iot_handler.go sudah dibalikin ke model ini)
 		handlers.StartInternalScheduler(app, targetUserID, targetDevice, syncInterval)
+
+		// Peringatan token listrik prabayar hampir habis
+		handlers.StartPrepaidAlertScheduler(app, 1*time.Hour)
+
+		// Peringatan proyeksi tagihan melewati anggaran bulanan
+		handlers.StartBudgetAlertScheduler(app, 1*time.Hour)
 	}
 	// =================================================================
 
+	// Purge sampah perangkat (soft delete) yang sudah lewat masa simpan
+	handlers.StartTrashPurgeScheduler(6 * time.Hour)
+
+	// Idempotency key submit yang sudah lewat masa berlaku
+	handlers.StartIdempotencyPurgeScheduler(1 * time.Hour)
+
+	// Benchmark anonim antar rumah tangga (kohort per daya, wilayah, jumlah penghuni)
+	handlers.StartBenchmarkScheduler(24 * time.Hour)
+
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
 		log.Fatalln("⚠️ GEMINI_API_KEY tidak ditemukan")
@@ -152,10 +167,19 @@ func main() {
 	router.HandleFunc("/statistics/monthly", handlers.GetMonthlyStatisticsHandler)
 	router.HandleFunc("/statistics/data-range", handlers.GetDataRangeHandler)
 	router.HandleFunc("/statistics/category", handlers.GetCategoryStatisticsHandler)
+	router.HandleFunc("/statistics/room", handlers.GetRoomStatisticsHandler)
+	router.HandleFunc("/statistics/bill", handlers.GetBillEstimateHandler)
+	router.HandleFunc("/statistics/series", handlers.GetUsageSeriesHandler)
+	router.HandleFunc("/statistics/compare/mom", handlers.CompareMonthOverMonthHandler)
+	router.HandleFunc("/statistics/compare/yoy", handlers.CompareYearOverYearHandler)
+	router.HandleFunc("/statistics/top-consumers", handlers.TopConsumersHandler)
+	router.HandleFunc("/benchmark", handlers.BenchmarkHandler)
 	router.HandleFunc("/history", handlers.GetDeviceHistoryHandler)
 	router.HandleFunc("/brands", handlers.GetBrandsHandler)
 	router.HandleFunc("/categories", handlers.GetCategoriesHandler)
-	router.HandleFunc("/submit", handlers.SubmitHandler)
+	router.HandleFunc("/submit", handlers.WithIdempotency("submit", handlers.SubmitHandler))
+	router.HandleFunc("/submissions", handlers.GetSubmissionsHandler)
+	router.HandleFunc("/submissions/diff", handlers.DiffSubmissionsHandler)
 
 	router.HandleFunc("/analyze", func(w http.ResponseWriter, r *http.Request) {
 		handlers.AnalyzeHandler(w, r, model)
@@ -168,6 +192,9 @@ func main() {
 	router.HandleFunc("/api/insight", handlers.GetInsightHandler)
 	router.HandleFunc("/api/devices", handlers.GetDevicesByBrandHandler)
 	router.HandleFunc("/house-capacity", handlers.GetHouseCapacityHandler)
+	router.HandleFunc("/house-capacity/recommendation", handlers.CapacityRecommendationHandler)
+	router.HandleFunc("/solar/cities", handlers.SolarCitiesHandler)
+	router.HandleFunc("/solar/simulate", handlers.SolarSimulationHandler)
 	router.HandleFunc("/api/devices/list", handlers.GetUniqueDevicesHandler)
 
 	// [FIX] Handler AI yang benar (Query ke User 16)
@@ -175,6 +202,30 @@ func main() {
 	
 	router.HandleFunc("/user/appliances/", handlers.GetApplianceByIDHandler)
 	router.HandleFunc("/user/profile", handlers.UpdateUserProfileHandler)
+	router.HandleFunc("/appliances/schedule", handlers.ApplianceScheduleHandler)
+	router.HandleFunc("/appliances/peak-load", handlers.PeakLoadHandler)
+	router.HandleFunc("/appliances/mcb-risk", handlers.McbRiskHandler)
+	router.HandleFunc("/appliances/tou", handlers.TouAnalysisHandler)
+	router.HandleFunc("/appliances/lifecycle", handlers.ApplianceLifecycleHandler)
+	router.HandleFunc("/appliances/replacements", handlers.ReplacementRecommendationsHandler)
+	router.HandleFunc("/appliances/create", handlers.WithIdempotency("appliances/create", handlers.CreateApplianceHandler))
+	router.HandleFunc("/appliances/delete", handlers.DeleteApplianceHandler)
+	router.HandleFunc("/appliances/trash", handlers.TrashHandler)
+	router.HandleFunc("/appliances/restore", handlers.RestoreApplianceHandler)
+	router.HandleFunc("/rooms", handlers.RoomsHandler)
+	router.HandleFunc("/rooms/assign", handlers.AssignRoomHandler)
+	router.HandleFunc("/iot/link", handlers.LinkIotDeviceHandler)
+	router.HandleFunc("/prepaid/tokens", handlers.PrepaidTokensHandler)
+	router.HandleFunc("/prepaid/readings", handlers.PrepaidReadingsHandler)
+	router.HandleFunc("/prepaid/forecast", handlers.PrepaidForecastHandler)
+	router.HandleFunc("/budget", handlers.BudgetHandler)
+	router.HandleFunc("/budget/status", handlers.BudgetStatusHandler)
+	router.HandleFunc("/bills", handlers.BillsHandler)
+	router.HandleFunc("/bills/reconciliation", handlers.BillReconciliationHandler)
+	router.HandleFunc("/regions", handlers.RegionsHandler)
+	router.HandleFunc("/admin/tariffs", handlers.AdminTariffsHandler)
+	router.HandleFunc("/admin/regions", handlers.AdminRegionsHandler)
+	router.HandleFunc("/admin/tou-tariffs", handlers.AdminTouTariffsHandler)
 
 	router.HandleFunc("/api/iot/input", func(w http.ResponseWriter, r *http.Request) {
 		handlers.IotInputHandler(w, r, app)
@@ -9,10 +9,15 @@ type Device struct {
 	Name             string  `json:"name"`             // Field frontend: 'name' (kecil)
 	Brand            string  `json:"brand"`            // Field frontend: 'brand' (kecil)
 	Power            int     `json:"power"`            // Field frontend: 'power' (kecil)
-	Duration         int     `json:"duration"`         // Field frontend: 'duration' (kecil)
+	Duration         float64 `json:"duration"`         // Jam per hari, boleh pecahan (0.5 = 30 menit)
+	DurationMinutes  float64 `json:"duration_minutes"` // Alternatif: menit per hari, dipakai kalau duration kosong
+	Quantity         int     `json:"quantity"`         // Jumlah unit, 0 dianggap 1
 	CategoryID       int     `json:"category_id"`
 	Weekly_Usage     float64 `json:"weekly_usage"`  // Optional, jika ingin dikirim/diterima
 	Monthly_Usage    float64 `json:"monthly_usage"` // Optional
 	Monthly_Cost     float64 `json:"monthly_cost"`  // Optional
 	Tanggal_Input    string  `json:"tanggal_input"` // Optional
+
+	// Jadwal pemakaian per hari (opsional). Kalau diisi, menggantikan Duration.
+	Schedule []UsageWindow `json:"schedule,omitempty"`
 }
@@ -0,0 +1,11 @@
+package models
+
+// UsageWindow adalah satu rentang pemakaian perangkat pada hari tertentu.
+// Weekday mengikuti time.Weekday (0 = Minggu ... 6 = Sabtu).
+// Start/End berformat "HH:MM"; kalau End <= Start berarti lewat tengah malam
+// (contoh AC malam 22:00 - 06:00) dan jamnya dihitung ke hari Start.
+type UsageWindow struct {
+	Weekday int    `json:"weekday"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}